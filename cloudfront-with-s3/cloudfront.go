@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"log"
 	"os"
 	"time"
 
@@ -163,7 +162,7 @@ func (c *CFClient) CreatePreSignedURl(url, keyId, privateKeyPath string) (string
 
 	privateKey, err := sign.LoadPEMPrivKeyFile(privateKeyPath)
 	if err != nil {
-		log.Println("Failed to load private key ", err)
+		cfLog.Error("private key load failed", "path", privateKeyPath, errAttr(err))
 		return "", err
 	}
 	urlSigner := sign.NewURLSigner(keyId, privateKey)
@@ -1,6 +1,6 @@
 module github.com/vubon/aws-examples/cloudfront-with-s3
 
-go 1.20
+go 1.21
 
 require (
 	github.com/aws/aws-sdk-go-v2 v1.21.0
@@ -2,7 +2,6 @@ package main
 
 import (
 	"encoding/json"
-	"log"
 	"os"
 )
 
@@ -32,7 +31,7 @@ func (m *Memory) getMemory() *Memory {
 	data, _ := os.ReadFile(filePath)
 	err = json.Unmarshal(data, m)
 	if err != nil {
-		log.Println("Memory read error ", err)
+		memLog.Error("memory read failed", "path", filePath, errAttr(err))
 		return m
 	}
 	return m
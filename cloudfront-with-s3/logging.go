@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Field names shared with the sqs-with-s3 consumer's logs.
+const (
+	fieldBucket         = "bucket"
+	fieldKey            = "key"
+	fieldDistributionID = "distributionId"
+	fieldKeyGroupID     = "keyGroupId"
+	fieldPublicKeyID    = "publicKeyId"
+	fieldError          = "error"
+)
+
+var (
+	logFormat = flag.String("log-format", "text", "Log format: text or json")
+	logLevel  = flag.String("log-level", "info", "Default log level: debug, info, warn or error")
+	logLevels = flag.String("log-levels", "", "Per component log levels, e.g. s3=debug,cloudfront=warn")
+)
+
+// Component loggers, replaced by setupLogging once the flags are parsed.
+var (
+	mainLog = slog.Default()
+	s3Log   = slog.Default()
+	cfLog   = slog.Default()
+	memLog  = slog.Default()
+)
+
+// setupLogging creates the component loggers from the log flags.
+func setupLogging() error {
+	if *logFormat != "text" && *logFormat != "json" {
+		return fmt.Errorf("unknown log format %q", *logFormat)
+	}
+	def, err := parseLevel(*logLevel)
+	if err != nil {
+		return err
+	}
+	levels := map[string]slog.Level{}
+	for _, pair := range strings.Split(*logLevels, ",") {
+		if pair == "" {
+			continue
+		}
+		name, level, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("log level %q is not component=level", pair)
+		}
+		if levels[name], err = parseLevel(level); err != nil {
+			return fmt.Errorf("component %s: %w", name, err)
+		}
+	}
+	logger := func(component string) *slog.Logger {
+		level, ok := levels[component]
+		if !ok {
+			level = def
+		}
+		opts := &slog.HandlerOptions{Level: level}
+		var h slog.Handler = slog.NewTextHandler(os.Stdout, opts)
+		if *logFormat == "json" {
+			h = slog.NewJSONHandler(os.Stdout, opts)
+		}
+		return slog.New(h).With("component", component)
+	}
+	mainLog = logger("main")
+	s3Log = logger("s3")
+	cfLog = logger("cloudfront")
+	memLog = logger("memory")
+	slog.SetDefault(mainLog)
+	return nil
+}
+
+func parseLevel(s string) (slog.Level, error) {
+	var l slog.Level
+	err := l.UnmarshalText([]byte(strings.ToUpper(s)))
+	return l, err
+}
+
+func errAttr(err error) slog.Attr {
+	return slog.Any(fieldError, err)
+}
@@ -5,7 +5,6 @@ import (
 	"encoding/csv"
 	"encoding/json"
 	"flag"
-	"log"
 	"net/url"
 	"os"
 	"strings"
@@ -77,11 +76,13 @@ func (c *Client) CreateBucket(bucketName string) {
 	location := c.S3C.CreateBucket(bucketName)
 	parsedURL, err := url.Parse(location)
 	if err != nil {
-		log.Println("Error parsing URL:", err)
+		s3Log.Error("bucket location parse failed", fieldBucket, bucketName, "location", location, errAttr(err))
+		return
 	}
 	memory.BucketName = bucketName
 	memory.BucketDomain = parsedURL.Host
 	_ = writeMemory(memory)
+	s3Log.Info("created bucket", fieldBucket, bucketName, "domain", memory.BucketDomain)
 }
 
 func (c *Client) BucketPolicyUpdate() {
@@ -112,14 +113,15 @@ func (c *Client) BucketPolicyUpdate() {
 	}
 	jsonData, err := json.Marshal(policy)
 	if err != nil {
-		log.Println("Policy update marshal error ", err)
+		s3Log.Error("bucket policy marshal failed", fieldBucket, mem.BucketName, errAttr(err))
 		return
 	}
 	err = c.S3C.PolicyUpdate(mem.BucketName, string(jsonData))
 	if err != nil {
-		log.Println("Policy update error ", err)
+		s3Log.Error("bucket policy update failed", fieldBucket, mem.BucketName, errAttr(err))
 		return
 	}
+	s3Log.Info("updated bucket policy", fieldBucket, mem.BucketName, "distributionArn", mem.DistributionArn)
 }
 
 func (c *Client) WithoutUpload() {
@@ -136,7 +138,7 @@ func (c *Client) WithoutUpload() {
 			// Write the record to the file
 			err := writer.Write([]string{formatMerchantId(*object.Key), newUrl})
 			if err != nil {
-				log.Println(err)
+				mainLog.Error("csv write failed", fieldKey, *object.Key, errAttr(err))
 			}
 		}
 	}
@@ -158,14 +160,14 @@ func (c *Client) WithUpload(dir string) {
 		if strings.HasSuffix(file.Name(), ".csv") {
 			content, err := os.ReadFile(dir + "/" + file.Name())
 			if err != nil {
-				log.Println("Could not find the file: ", file.Name(), " ", err)
+				mainLog.Error("file read failed", "file", file.Name(), errAttr(err))
 			}
 			c.S3C.Upload(file.Name(), mem.BucketName, bytes.NewReader(content))
 			newUrl := c.PreSignedURL(mem.CFKeyId, mem.CloudFrontDomain+"/"+file.Name())
 			// Write the record to the file
 			err = writer.Write([]string{formatMerchantId(file.Name()), newUrl})
 			if err != nil {
-				log.Println(err)
+				mainLog.Error("csv write failed", fieldKey, file.Name(), errAttr(err))
 			}
 		}
 	}
@@ -174,7 +176,7 @@ func (c *Client) WithUpload(dir string) {
 func (c *Client) PreSignedURL(keyId, url string) string {
 	newUrl, err := c.CFC.CreatePreSignedURl(url, keyId, "private_key.pem")
 	if err != nil {
-		log.Println("URL generate URL ", err)
+		cfLog.Error("signed URL generation failed", "url", url, fieldPublicKeyID, keyId, errAttr(err))
 		return ""
 	}
 	return newUrl
@@ -188,25 +190,27 @@ func (c *Client) UploadCertificate() (string, string) {
 	}
 	file, err := os.ReadFile("public_key.pem")
 	if err != nil {
-		log.Println("public key read error ", err)
+		cfLog.Error("public key read failed", errAttr(err))
 		return "", ""
 	}
 
 	comment := "Server S3 object Presigned URL"
 	keyId, err := c.CFC.CreatePublicKey("bucket", comment, string(file))
 	if err != nil {
-		log.Println("Public key upload error ", err)
+		cfLog.Error("public key upload failed", errAttr(err))
 		return "", ""
 	}
+	cfLog.Info("uploaded public key", fieldPublicKeyID, keyId)
 	// Write memory
 	memory.CFKeyId = keyId
 	_ = writeMemory(memory)
 
 	groupId, err := c.CFC.CreateKeyGroup("bucket", comment, []string{keyId})
 	if err != nil {
-		log.Println("public key group error", err)
+		cfLog.Error("key group creation failed", fieldPublicKeyID, keyId, errAttr(err))
 		return "", ""
 	}
+	cfLog.Info("created key group", fieldKeyGroupID, groupId, fieldPublicKeyID, keyId)
 	// Write memory
 	memory.CFGroupId = groupId
 	_ = writeMemory(memory)
@@ -219,10 +223,11 @@ func (c *Client) Distribution() {
 	comment := "Server S3 object Presigned URL"
 	created, err := c.CFC.CreateDistributions(comment, memory.BucketDomain, []string{memory.CFGroupId})
 	if err != nil {
-		log.Println("Creation Distribution Error ", err)
+		cfLog.Error("distribution creation failed", fieldBucket, memory.BucketName, fieldKeyGroupID, memory.CFGroupId, errAttr(err))
 		return
 	}
-	log.Println(*created.DomainName, *created.Status, *created.Id, *created.ARN)
+	cfLog.Info("created distribution", fieldDistributionID, *created.Id, "domain", *created.DomainName,
+		"status", *created.Status, "arn", *created.ARN, fieldKeyGroupID, memory.CFGroupId)
 
 	// Write memory
 	memory.CloudFrontDomain = "https://" + *created.DomainName
@@ -232,10 +237,18 @@ func (c *Client) Distribution() {
 
 func main() {
 	flag.Parse()
+	if err := setupLogging(); err != nil {
+		mainLog.Error("logging setup failed", errAttr(err))
+		os.Exit(2)
+	}
 	s3c, err := New()
+	if err != nil {
+		mainLog.Error("s3 client init failed", errAttr(err))
+		return
+	}
 	cfc, err := NewCFClient()
 	if err != nil {
-		log.Println("Client init error ", err)
+		mainLog.Error("cloudfront client init failed", errAttr(err))
 		return
 	}
 	mc := NewMemory()
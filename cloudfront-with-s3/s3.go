@@ -3,7 +3,6 @@ package main
 import (
 	"context"
 	"io"
-	"log"
 	"os"
 	"time"
 
@@ -73,7 +72,7 @@ func (c *S3Client) CreateBucket(name string) string {
 		},
 	})
 	if err != nil {
-		log.Println("bucket create error ", err)
+		s3Log.Error("bucket creation failed", fieldBucket, name, errAttr(err))
 		return ""
 	}
 
@@ -98,9 +97,10 @@ func (c *S3Client) Upload(key, bucket string, body io.Reader) bool {
 		Body:   body,
 	})
 	if err != nil {
-		log.Println("File cloudfront-with-s3 error Key: ", key)
+		s3Log.Error("upload failed", fieldBucket, bucket, fieldKey, key, errAttr(err))
 		return false
 	}
+	s3Log.Debug("uploaded object", fieldBucket, bucket, fieldKey, key)
 	return true
 }
 
@@ -109,7 +109,7 @@ func (c *S3Client) GetFileList(bucket string) []types.Object {
 		Bucket: aws.String(bucket),
 	})
 	if err != nil {
-		log.Println("Get Object list error ", err)
+		s3Log.Error("object list failed", fieldBucket, bucket, errAttr(err))
 		return nil
 	}
 	return result.Contents
@@ -133,7 +133,8 @@ func (c *S3Client) GeneratePreSignedURL(key, bucket string, body io.Reader) stri
 	if ok {
 		object, err := c.PresignGetObject(key, bucket)
 		if err != nil {
-			log.Println("PreSign URL generation error ", err)
+			s3Log.Error("presigned URL generation failed", fieldBucket, bucket, fieldKey, key, errAttr(err))
+			return ""
 		}
 		return object.URL
 	}
@@ -0,0 +1,130 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vubon/aws-examples/sqs-with-s3/sqs"
+)
+
+const defaultDrainTimeout = 60 * time.Second
+
+// Mux serves the admin endpoints under /admin/. Every request must carry
+// the configured bearer token.
+type Mux struct {
+	token string
+	mux   *http.ServeMux
+}
+
+// New creates the admin endpoints for the consumer group.
+func New(token string, group *sqs.Group) *Mux {
+	m := &Mux{token: token, mux: http.NewServeMux()}
+	m.HandleFunc("/admin/state", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, group.States())
+	})
+	m.HandleFunc("/admin/pause", post(func(w http.ResponseWriter, r *http.Request) {
+		consumers, ok := selectQueue(w, r, group)
+		if !ok {
+			return
+		}
+		for _, c := range consumers {
+			c.Pause()
+		}
+		writeJSON(w, http.StatusOK, group.States())
+	}))
+	m.HandleFunc("/admin/resume", post(func(w http.ResponseWriter, r *http.Request) {
+		consumers, ok := selectQueue(w, r, group)
+		if !ok {
+			return
+		}
+		for _, c := range consumers {
+			c.Resume()
+		}
+		writeJSON(w, http.StatusOK, group.States())
+	}))
+	m.HandleFunc("/admin/drain", post(func(w http.ResponseWriter, r *http.Request) {
+		consumers, ok := selectQueue(w, r, group)
+		if !ok {
+			return
+		}
+		timeout := defaultDrainTimeout
+		if t := r.URL.Query().Get("timeout"); t != "" {
+			d, err := time.ParseDuration(t)
+			if err != nil {
+				http.Error(w, "invalid timeout", http.StatusBadRequest)
+				return
+			}
+			timeout = d
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		status := http.StatusOK
+		if err := group.Drain(ctx, consumers); err != nil {
+			status = http.StatusGatewayTimeout
+		}
+		writeJSON(w, status, group.States())
+	}))
+	m.HandleFunc("/admin/workers", post(func(w http.ResponseWriter, r *http.Request) {
+		count, err := strconv.Atoi(r.URL.Query().Get("count"))
+		if err != nil || count < 1 {
+			http.Error(w, "count must be a positive number", http.StatusBadRequest)
+			return
+		}
+		consumers, ok := selectQueue(w, r, group)
+		if !ok {
+			return
+		}
+		for _, c := range consumers {
+			c.SetWorkers(count)
+		}
+		writeJSON(w, http.StatusOK, group.States())
+	}))
+	return m
+}
+
+// HandleFunc registers an admin endpoint behind the bearer token.
+func (m *Mux) HandleFunc(pattern string, fn http.HandlerFunc) {
+	m.mux.HandleFunc(pattern, fn)
+}
+
+func (m *Mux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if m.token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(m.token)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	m.mux.ServeHTTP(w, r)
+}
+
+func post(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		fn(w, r)
+	}
+}
+
+// selectQueue picks the consumer named by the queue parameter, or all of them.
+func selectQueue(w http.ResponseWriter, r *http.Request, group *sqs.Group) ([]*sqs.Consumer, bool) {
+	consumers, err := group.Select(r.URL.Query().Get("queue"))
+	if errors.Is(err, sqs.ErrUnknownQueue) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return nil, false
+	}
+	return consumers, true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,65 @@
+// Package app sets up what the long-running consumer and the Lambda function
+// have in common, so that both handle objects the same way.
+package app
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/vubon/aws-examples/sqs-with-s3/config"
+	"github.com/vubon/aws-examples/sqs-with-s3/handler"
+	"github.com/vubon/aws-examples/sqs-with-s3/handler/command"
+	"github.com/vubon/aws-examples/sqs-with-s3/handler/convert"
+	"github.com/vubon/aws-examples/sqs-with-s3/handler/csvvalidate"
+	"github.com/vubon/aws-examples/sqs-with-s3/handler/mirror"
+	"github.com/vubon/aws-examples/sqs-with-s3/handler/thumbnail"
+	"github.com/vubon/aws-examples/sqs-with-s3/handler/webhook"
+	"github.com/vubon/aws-examples/sqs-with-s3/s3"
+	"github.com/vubon/aws-examples/sqs-with-s3/tracing"
+)
+
+// Init sets up tracing, downloads and client-side decryption from cfg, and
+// registers and configures the handlers. The returned function flushes and
+// stops the trace exporter.
+func Init(ctx context.Context, sess *session.Session, cfg *config.Config) (func(context.Context) error, error) {
+	shutdown, err := tracing.Setup(ctx, cfg.Tracing)
+	if err != nil {
+		return nil, fmt.Errorf("tracing setup: %w", err)
+	}
+	tracing.Instrument(sess)
+	s3.Configure(cfg.Downloads)
+	if err := decryption(sess, cfg.Decryption); err != nil {
+		shutdown(ctx)
+		return nil, err
+	}
+	handler.Register("print", handler.Print(sess))
+	handler.RegisterType("csv-validate", csvvalidate.New)
+	handler.RegisterType("convert", convert.New)
+	handler.RegisterType("thumbnail", thumbnail.New)
+	handler.RegisterType("command", command.New)
+	handler.RegisterType("webhook", webhook.New)
+	handler.RegisterType("mirror", mirror.New)
+	if err := handler.Configure(sess, cfg.Handlers); err != nil {
+		shutdown(ctx)
+		return nil, fmt.Errorf("handler config: %w", err)
+	}
+	return shutdown, nil
+}
+
+func decryption(sess *session.Session, d *config.Decryption) error {
+	if d == nil {
+		return nil
+	}
+	maxSize := int64(d.MaxSizeMB) << 20
+	if d.Provider == "kms" {
+		s3.SetKeyProvider(s3.NewKMSProvider(sess, d.KMSKeyID), maxSize)
+		return nil
+	}
+	provider, err := s3.NewKeyFileProvider(d.KeyFile)
+	if err != nil {
+		return fmt.Errorf("decryption key load: %w", err)
+	}
+	s3.SetKeyProvider(provider, maxSize)
+	return nil
+}
@@ -0,0 +1,94 @@
+// Command lambda runs the consumer's handlers as an AWS Lambda function with
+// an SQS trigger. Given -event, it instead invokes the adapter once with a
+// fixture event and prints the batch response, without touching the queue.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/vubon/aws-examples/sqs-with-s3/app"
+	"github.com/vubon/aws-examples/sqs-with-s3/config"
+	"github.com/vubon/aws-examples/sqs-with-s3/logging"
+	"github.com/vubon/aws-examples/sqs-with-s3/outcome"
+	"github.com/vubon/aws-examples/sqs-with-s3/sqs"
+)
+
+var (
+	configPath = flag.String("config", "config.json", "Consumer config file")
+	eventPath  = flag.String("event", "", "Invoke once with this SQS event file instead of starting the Lambda runtime")
+
+	log = logging.For("lambda")
+)
+
+func main() {
+	flag.Parse()
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Error("config load failed", logging.Err(err))
+		os.Exit(1)
+	}
+	if err := logging.Setup(cfg.Logging); err != nil {
+		log.Error("logging setup failed", logging.Err(err))
+		os.Exit(1)
+	}
+
+	sess := session.Must(session.NewSessionWithOptions(session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+	}))
+	shutdown, err := app.Init(context.Background(), sess, cfg)
+	if err != nil {
+		log.Error("setup failed", logging.Err(err))
+		os.Exit(1)
+	}
+	defer shutdown(context.Background())
+
+	publishers, err := outcome.FromConfig(sess, cfg.Results)
+	if err != nil {
+		log.Error("outcome publisher setup failed", logging.Err(err))
+		os.Exit(1)
+	}
+	adapter, err := sqs.NewLambda(sess, cfg, &sqs.Shared{Publisher: publishers})
+	if err != nil {
+		log.Error("consumer setup failed", logging.Err(err))
+		os.Exit(1)
+	}
+
+	if *eventPath == "" {
+		lambda.Start(adapter.Handle)
+		return
+	}
+	adapter.Local = true
+	if err := invoke(adapter, *eventPath); err != nil {
+		log.Error("invoke failed", logging.Err(err))
+		os.Exit(1)
+	}
+}
+
+// invoke runs the adapter with the SQS event in path and prints its response.
+func invoke(adapter *sqs.Lambda, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var event events.SQSEvent
+	if err := json.Unmarshal(data, &event); err != nil {
+		return err
+	}
+	resp, err := adapter.Handle(context.Background(), event)
+	if err != nil {
+		return err
+	}
+	out, err := json.MarshalIndent(resp, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(out))
+	return nil
+}
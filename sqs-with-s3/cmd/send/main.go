@@ -0,0 +1,102 @@
+// Command send puts a message on a queue, offloading bodies too large for
+// SQS to S3 the way the SQS Extended Client does, so that either the
+// consumer or the Java extended client can read them.
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"io"
+	"os"
+	"path"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/vubon/aws-examples/sqs-with-s3/extended"
+	"github.com/vubon/aws-examples/sqs-with-s3/logging"
+	"github.com/vubon/aws-examples/sqs-with-s3/s3"
+)
+
+var (
+	queue     = flag.String("queue", "", "Queue name")
+	bucket    = flag.String("bucket", "", "Bucket for offloaded payloads")
+	prefix    = flag.String("prefix", "", "Key prefix for offloaded payloads")
+	file      = flag.String("file", "", "Message body file, or stdin when empty")
+	threshold = flag.Int("threshold", extended.MaxMessageSize, "Offload bodies larger than this many bytes")
+	always    = flag.Bool("always", false, "Offload every body regardless of size")
+
+	log = logging.For("send")
+)
+
+func main() {
+	flag.Parse()
+	if *queue == "" {
+		log.Error("-queue is required")
+		os.Exit(2)
+	}
+	body, err := readBody(*file)
+	if err != nil {
+		log.Error("body read failed", logging.Err(err))
+		os.Exit(1)
+	}
+
+	sess := session.Must(session.NewSessionWithOptions(session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+	}))
+	svc := sqs.New(sess)
+	url, err := svc.GetQueueUrl(&sqs.GetQueueUrlInput{QueueName: queue})
+	if err != nil {
+		log.Error("queue URL lookup failed", logging.Err(err))
+		os.Exit(1)
+	}
+
+	input := &sqs.SendMessageInput{
+		QueueUrl:    url.QueueUrl,
+		MessageBody: aws.String(string(body)),
+	}
+	if *always || len(body) > *threshold {
+		if *bucket == "" {
+			log.Error("-bucket is required to offload the body", "size", len(body))
+			os.Exit(2)
+		}
+		pointer := &extended.Pointer{Bucket: *bucket, Key: path.Join(*prefix, newKey())}
+		if _, err := s3.Upload(context.Background(), sess, pointer.Bucket, pointer.Key, "", bytes.NewReader(body)); err != nil {
+			log.Error("payload upload failed", logging.Err(err))
+			os.Exit(1)
+		}
+		input.MessageBody = aws.String(pointer.Body())
+		input.MessageAttributes = map[string]*sqs.MessageAttributeValue{
+			extended.SizeAttribute: {
+				DataType:    aws.String("Number"),
+				StringValue: aws.String(strconv.Itoa(len(body))),
+			},
+		}
+		log.Info("offloaded payload", logging.Bucket, pointer.Bucket, logging.Key, pointer.Key, "size", len(body))
+	}
+
+	out, err := svc.SendMessage(input)
+	if err != nil {
+		log.Error("send failed", logging.Err(err))
+		os.Exit(1)
+	}
+	log.Info("sent message", logging.MessageID, aws.StringValue(out.MessageId))
+}
+
+// newKey returns a random object name for a payload.
+func newKey() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func readBody(name string) ([]byte, error) {
+	if name == "" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(name)
+}
@@ -0,0 +1,358 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Config describes every queue a consumer process watches.
+type Config struct {
+	// Listen is the address of the HTTP server for health and metrics.
+	Listen string `json:"listen"`
+	// MaxConcurrency caps the number of messages handled at once across all queues.
+	MaxConcurrency int     `json:"maxConcurrency"`
+	Queues         []Queue `json:"queues"`
+	// RateLimits optionally throttles handler runs across all queues.
+	RateLimits *RateLimits `json:"rateLimits"`
+	// Results optionally publishes an outcome message for every handler run.
+	Results *Results `json:"results"`
+	// History optionally keeps processed events in an embedded store.
+	History *History `json:"history"`
+	// Handlers are named handler instances for routes to use. Each is a JSON
+	// object whose "type" picks the built-in handler; the rest are its options.
+	Handlers map[string]json.RawMessage `json:"handlers"`
+	// Admin optionally enables the /admin/ endpoints.
+	Admin *Admin `json:"admin"`
+	// Downloads optionally fetches large objects with parallel ranged GETs.
+	Downloads *Downloads `json:"downloads"`
+	// Restore optionally restores archived objects and handles them once
+	// they are readable.
+	Restore *Restore `json:"restore"`
+	// Decryption optionally decrypts objects encrypted client-side by the
+	// S3 Encryption Client.
+	Decryption *Decryption `json:"decryption"`
+	// Tracing optionally exports OpenTelemetry traces of message handling.
+	Tracing *Tracing `json:"tracing"`
+	// Logging optionally sets the log format and levels.
+	Logging *Logging `json:"logging"`
+}
+
+// Logging writes "text" (default) or "json" lines to stdout. Level is the
+// default level, "info" unless set, and Components overrides it per
+// component, e.g. {"sqs": "debug"}.
+type Logging struct {
+	Format     string            `json:"format"`
+	Level      string            `json:"level"`
+	Components map[string]string `json:"components"`
+}
+
+// Tracing exports spans with Exporter "otlp" (default), over OTLP/HTTP to
+// Endpoint, or "stdout", which prints spans to stderr so they stay apart from
+// the logs. An OTLP collector that cannot be reached at startup falls back to
+// it.
+type Tracing struct {
+	Exporter string `json:"exporter"`
+	// Endpoint is the collector's host:port, "localhost:4318" by default.
+	Endpoint string `json:"endpoint"`
+	// Insecure sends spans over plain HTTP, as to a local collector.
+	Insecure    bool   `json:"insecure"`
+	ServiceName string `json:"serviceName"`
+	// SampleRatio is the fraction of new traces recorded, 1 by default.
+	// Traces continued from a message follow the sender's decision.
+	SampleRatio float64 `json:"sampleRatio"`
+}
+
+// Decryption picks the key provider that unwraps data keys: "kms", with an
+// optional KMSKeyID to restrict it to, or "keyfile" with a base64 encoded
+// 256-bit KeyFile for development.
+type Decryption struct {
+	Provider string `json:"provider"`
+	KeyFile  string `json:"keyFile"`
+	KMSKeyID string `json:"kmsKeyId"`
+	// MaxSizeMB bounds the objects decrypted, which are held in memory
+	// until authenticated, 64 by default. Larger objects are dead-lettered.
+	MaxSizeMB int `json:"maxSizeMB"`
+}
+
+// Restore is how archived objects are restored. Pending restores are kept in
+// a BoltDB file at Path.
+type Restore struct {
+	// Tier is the retrieval tier: Standard (default), Bulk or Expedited.
+	Tier string `json:"tier"`
+	// Days the restored copy stays readable, 3 by default.
+	Days int    `json:"days"`
+	Path string `json:"path"`
+	// RetentionHours keeps completed entries, 7 days by default.
+	RetentionHours int `json:"retentionHours"`
+}
+
+// Downloads splits objects of at least ThresholdMB into PartSizeMB ranges
+// fetched Concurrency at a time. Parts are reassembled in order in memory, or
+// in a temporary file with TempFile.
+type Downloads struct {
+	ThresholdMB int  `json:"thresholdMB"`
+	PartSizeMB  int  `json:"partSizeMB"`
+	Concurrency int  `json:"concurrency"`
+	TempFile    bool `json:"tempFile"`
+}
+
+// Admin protects the admin endpoints with a bearer token.
+type Admin struct {
+	Token string `json:"token"`
+}
+
+// History is the embedded event store served on /events.
+type History struct {
+	Path string `json:"path"`
+	// RetentionHours is how long records are kept, 7 days by default.
+	RetentionHours int `json:"retentionHours"`
+}
+
+// Results names where outcome messages are published. Either or both can be set;
+// FIFO queues and topics (".fifo") get group and deduplication IDs.
+type Results struct {
+	Queue    string `json:"queue"`
+	TopicArn string `json:"topicArn"`
+}
+
+// RateLimits are token buckets applied before a handler runs. Buckets and
+// Handlers are keyed by bucket or handler name, with "*" as the default.
+type RateLimits struct {
+	Global   *Rate           `json:"global"`
+	Buckets  map[string]Rate `json:"buckets"`
+	Handlers map[string]Rate `json:"handlers"`
+}
+
+// Rate is a token bucket of Burst tokens refilled at PerSecond.
+type Rate struct {
+	PerSecond float64 `json:"perSecond"`
+	Burst     int     `json:"burst"`
+}
+
+// Queue is one SQS queue with its own poller, workers, routes and DLQ.
+type Queue struct {
+	Name string `json:"name"`
+	// DeadLetterQueue receives messages whose errors will never succeed.
+	// Leave it empty to rely on the queue's redrive policy instead.
+	DeadLetterQueue string `json:"deadLetterQueue"`
+	// Workers is the number of messages from this queue handled at once.
+	Workers int     `json:"workers"`
+	Routes  []Route `json:"routes"`
+	// Autoscale optionally sizes the worker pool to the queue backlog.
+	Autoscale *Autoscale `json:"autoscale"`
+	// PostActions optionally tag or archive objects once their handlers succeed.
+	PostActions *PostActions `json:"postActions"`
+	// DeletePayloads deletes the S3 payload of an extended client pointer
+	// message once the message is acknowledged.
+	DeletePayloads bool `json:"deletePayloads"`
+}
+
+// PostActions run on the source object of ObjectCreated events after every
+// matching handler succeeded.
+type PostActions struct {
+	// Tag sets processed=true and processed-at on the object.
+	Tag bool `json:"tag"`
+	// ArchiveBucket and ArchivePrefix move the object with CopyObject and
+	// DeleteObject. An empty bucket archives within the source bucket.
+	ArchiveBucket string `json:"archiveBucket"`
+	ArchivePrefix string `json:"archivePrefix"`
+	// StorageClass of the archive copy, e.g. GLACIER_IR. Empty keeps the bucket default.
+	StorageClass string `json:"storageClass"`
+	// DeleteVersions permanently deletes the archived version on versioned
+	// buckets. By default a delete marker is added and the version is kept.
+	DeleteVersions bool `json:"deleteVersions"`
+}
+
+// Archives reports whether objects are moved after processing.
+func (p *PostActions) Archives() bool {
+	return p.ArchiveBucket != "" || p.ArchivePrefix != ""
+}
+
+// Autoscale bounds the worker pool of a queue and sets how it follows the backlog.
+type Autoscale struct {
+	MinWorkers int `json:"minWorkers"`
+	MaxWorkers int `json:"maxWorkers"`
+	// IntervalSeconds is how often the queue attributes are read.
+	IntervalSeconds int `json:"intervalSeconds"`
+	// MessagesPerWorker is the backlog one worker is expected to keep up with.
+	MessagesPerWorker int `json:"messagesPerWorker"`
+	// ScaleDownChecks is how many readings in a row must ask for fewer workers
+	// before the pool shrinks.
+	ScaleDownChecks int `json:"scaleDownChecks"`
+}
+
+// Route sends matching events to a named handler. Empty fields match everything.
+type Route struct {
+	Handler string `json:"handler"`
+	// Events are event name prefixes such as "ObjectCreated:".
+	Events    []string `json:"events"`
+	KeyPrefix string   `json:"keyPrefix"`
+	KeySuffix string   `json:"keySuffix"`
+	// ExpandArchives runs the handler once per file of zip and tar objects
+	// instead of once for the archive.
+	ExpandArchives bool `json:"expandArchives"`
+}
+
+// Load reads a JSON config file and fills in defaults.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("config %s: %w", path, err)
+	}
+	if len(cfg.Queues) == 0 {
+		return nil, errors.New("config: no queues configured")
+	}
+	if cfg.Listen == "" {
+		cfg.Listen = ":8080"
+	}
+	if h := cfg.History; h != nil {
+		if h.Path == "" {
+			h.Path = "history.db"
+		}
+		if h.RetentionHours <= 0 {
+			h.RetentionHours = 7 * 24
+		}
+	}
+	if d := cfg.Downloads; d != nil {
+		if d.PartSizeMB <= 0 {
+			d.PartSizeMB = 8
+		}
+		if d.ThresholdMB <= 0 {
+			d.ThresholdMB = 64
+		}
+		if d.Concurrency <= 0 {
+			d.Concurrency = 4
+		}
+	}
+	if r := cfg.Restore; r != nil {
+		switch r.Tier {
+		case "":
+			r.Tier = "Standard"
+		case "Standard", "Bulk", "Expedited":
+		default:
+			return nil, fmt.Errorf("config: unknown restore tier %q", r.Tier)
+		}
+		if r.Days <= 0 {
+			r.Days = 3
+		}
+		if r.Path == "" {
+			r.Path = "restores.db"
+		}
+		if r.RetentionHours <= 0 {
+			r.RetentionHours = 7 * 24
+		}
+	}
+	if d := cfg.Decryption; d != nil {
+		switch {
+		case d.Provider == "kms":
+		case d.Provider == "keyfile" && d.KeyFile != "":
+		case d.Provider == "keyfile":
+			return nil, errors.New("config: decryption keyfile provider needs a keyFile")
+		default:
+			return nil, fmt.Errorf("config: unknown decryption provider %q", d.Provider)
+		}
+	}
+	if l := cfg.Logging; l != nil && l.Format != "" && l.Format != "text" && l.Format != "json" {
+		return nil, fmt.Errorf("config: unknown log format %q", l.Format)
+	}
+	if t := cfg.Tracing; t != nil {
+		switch t.Exporter {
+		case "":
+			t.Exporter = "otlp"
+		case "otlp", "stdout":
+		default:
+			return nil, fmt.Errorf("config: unknown tracing exporter %q", t.Exporter)
+		}
+		if t.Endpoint == "" {
+			t.Endpoint = "localhost:4318"
+		}
+		if t.ServiceName == "" {
+			t.ServiceName = "sqs-with-s3"
+		}
+		if t.SampleRatio <= 0 || t.SampleRatio > 1 {
+			t.SampleRatio = 1
+		}
+	}
+	if cfg.Admin != nil && cfg.Admin.Token == "" {
+		return nil, errors.New("config: admin needs a token")
+	}
+	if err := cfg.RateLimits.validate(); err != nil {
+		return nil, err
+	}
+	workers := 0
+	for i := range cfg.Queues {
+		q := &cfg.Queues[i]
+		if q.Name == "" {
+			return nil, fmt.Errorf("config: queue %d has no name", i)
+		}
+		if q.Workers <= 0 {
+			q.Workers = 2
+		}
+		if a := q.Autoscale; a != nil {
+			if a.MinWorkers <= 0 {
+				a.MinWorkers = 1
+			}
+			if a.MaxWorkers < a.MinWorkers {
+				a.MaxWorkers = a.MinWorkers
+			}
+			if a.IntervalSeconds <= 0 {
+				a.IntervalSeconds = 30
+			}
+			if a.MessagesPerWorker <= 0 {
+				a.MessagesPerWorker = 10
+			}
+			if a.ScaleDownChecks <= 0 {
+				a.ScaleDownChecks = 3
+			}
+			if q.Workers < a.MinWorkers {
+				q.Workers = a.MinWorkers
+			}
+			if q.Workers > a.MaxWorkers {
+				q.Workers = a.MaxWorkers
+			}
+		}
+		if p := q.PostActions; p != nil && p.StorageClass != "" && !p.Archives() {
+			return nil, fmt.Errorf("config: queue %s storageClass needs an archive bucket or prefix", q.Name)
+		}
+		if len(q.Routes) == 0 {
+			q.Routes = []Route{{Handler: "print", Events: []string{"ObjectCreated:"}}}
+		}
+		if q.Autoscale != nil {
+			workers += q.Autoscale.MaxWorkers
+		} else {
+			workers += q.Workers
+		}
+	}
+	if cfg.MaxConcurrency <= 0 {
+		cfg.MaxConcurrency = workers
+	}
+	return cfg, nil
+}
+
+func (r *RateLimits) validate() error {
+	if r == nil {
+		return nil
+	}
+	rates := map[string]Rate{}
+	if r.Global != nil {
+		rates["global"] = *r.Global
+	}
+	for name, rate := range r.Buckets {
+		rates["bucket "+name] = rate
+	}
+	for name, rate := range r.Handlers {
+		rates["handler "+name] = rate
+	}
+	for name, rate := range rates {
+		if rate.PerSecond <= 0 {
+			return fmt.Errorf("config: rate limit %s needs a positive perSecond", name)
+		}
+	}
+	return nil
+}
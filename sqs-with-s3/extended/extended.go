@@ -0,0 +1,58 @@
+package extended
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// Message attributes that carry the payload size of a pointer message. The
+// legacy name is used by older versions of the extended client.
+const (
+	SizeAttribute       = "ExtendedPayloadSize"
+	LegacySizeAttribute = "SQSLargePayloadSize"
+)
+
+// pointerClass tags the pointer in the message body.
+const pointerClass = "software.amazon.payloadoffloading.PayloadS3Pointer"
+
+// MaxMessageSize is the largest body SQS accepts, and the default size above
+// which payloads are offloaded.
+const MaxMessageSize = 256 << 10
+
+// Pointer names the S3 object holding the payload of a message, following
+// the SQS Extended Client convention.
+type Pointer struct {
+	Bucket string `json:"s3BucketName"`
+	Key    string `json:"s3Key"`
+}
+
+// Parse returns the pointer in a message whose payload was offloaded to S3.
+// Messages are recognised by their size attribute, and bodies may be the
+// current ["<class>", {...}] form or the legacy bare object.
+func Parse(msg *sqs.Message) (*Pointer, bool) {
+	if msg.MessageAttributes[SizeAttribute] == nil && msg.MessageAttributes[LegacySizeAttribute] == nil {
+		return nil, false
+	}
+	body := strings.TrimSpace(aws.StringValue(msg.Body))
+	p := &Pointer{}
+	var tagged []json.RawMessage
+	if err := json.Unmarshal([]byte(body), &tagged); err == nil {
+		if len(tagged) != 2 {
+			return nil, false
+		}
+		body = string(tagged[1])
+	}
+	if err := json.Unmarshal([]byte(body), p); err != nil || p.Bucket == "" || p.Key == "" {
+		return nil, false
+	}
+	return p, true
+}
+
+// Body is the message body of a pointer, in the current form.
+func (p *Pointer) Body() string {
+	body, _ := json.Marshal([]interface{}{pointerClass, p})
+	return string(body)
+}
@@ -0,0 +1,235 @@
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/vubon/aws-examples/sqs-with-s3/handler"
+	"github.com/vubon/aws-examples/sqs-with-s3/retry"
+	"github.com/vubon/aws-examples/sqs-with-s3/s3"
+)
+
+const (
+	InputStdin = "stdin"
+	InputFile  = "file"
+	InputNone  = "none"
+)
+
+// Options configure a command handler.
+type Options struct {
+	// Command is the executable and its arguments.
+	Command []string `json:"command"`
+	// Input is how the object reaches the command: stdin (default), file
+	// (a temporary file named by OBJECT_FILE) or none.
+	Input string `json:"input"`
+	// TimeoutSeconds kills the command after this long, 300 by default.
+	TimeoutSeconds int `json:"timeoutSeconds"`
+	// Concurrency is how many runs of this command may run at once, 1 by default.
+	Concurrency int `json:"concurrency"`
+	// RetryExitCodes requeue the message, [75] (EX_TEMPFAIL) by default. Any
+	// other non-zero exit code dead-letters it.
+	RetryExitCodes []int `json:"retryExitCodes"`
+	// MaxOutputBytes caps the stdout and stderr kept for the outcome, 64 KB by default.
+	MaxOutputBytes int `json:"maxOutputBytes"`
+	// Env adds variables to the command environment.
+	Env map[string]string `json:"env"`
+}
+
+type Command struct {
+	sess  *session.Session
+	opts  Options
+	slots chan struct{}
+}
+
+// New creates a command handler. It implements handler.Factory.
+func New(sess *session.Session, options json.RawMessage) (handler.Handler, error) {
+	c := &Command{sess: sess}
+	if err := json.Unmarshal(options, &c.opts); err != nil {
+		return nil, err
+	}
+	if len(c.opts.Command) == 0 {
+		return nil, errors.New("command handler needs a command")
+	}
+	switch c.opts.Input {
+	case "":
+		c.opts.Input = InputStdin
+	case InputStdin, InputFile, InputNone:
+	default:
+		return nil, fmt.Errorf("command handler: unknown input %q", c.opts.Input)
+	}
+	if c.opts.TimeoutSeconds <= 0 {
+		c.opts.TimeoutSeconds = 300
+	}
+	if c.opts.Concurrency <= 0 {
+		c.opts.Concurrency = 1
+	}
+	if c.opts.RetryExitCodes == nil {
+		c.opts.RetryExitCodes = []int{75}
+	}
+	if c.opts.MaxOutputBytes <= 0 {
+		c.opts.MaxOutputBytes = 64 << 10
+	}
+	c.slots = make(chan struct{}, c.opts.Concurrency)
+	return c, nil
+}
+
+// Handle runs the command for the event. Exit code 0 acks the message, a
+// retry exit code or a timeout requeues it, and any other failure
+// dead-letters it. Stdout, stderr and the exit code go into the outcome.
+func (c *Command) Handle(ctx context.Context, ev *handler.Event) (*handler.Result, error) {
+	select {
+	case c.slots <- struct{}{}:
+		defer func() { <-c.slots }()
+	case <-ctx.Done():
+		return nil, retry.Classify(retry.Requeue, ctx.Err())
+	}
+
+	eventJSON, err := json.Marshal(ev)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(c.opts.TimeoutSeconds)*time.Second)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, c.opts.Command[0], c.opts.Command[1:]...)
+	cmd.WaitDelay = 5 * time.Second
+	cmd.Env = append(os.Environ(),
+		"S3_EVENT="+string(eventJSON),
+		"S3_EVENT_NAME="+ev.EventName,
+		"S3_BUCKET="+ev.Bucket,
+		"S3_KEY="+ev.Key,
+		"S3_ENTRY="+ev.Entry,
+		"S3_SIZE="+strconv.FormatInt(ev.Size, 10),
+		"S3_ETAG="+ev.ETag,
+		"S3_VERSION_ID="+ev.VersionID,
+		"SQS_MESSAGE_ID="+ev.MessageID,
+		"SQS_QUEUE="+ev.Queue,
+	)
+	for k, v := range c.opts.Env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	stdout := &limitedBuffer{max: c.opts.MaxOutputBytes}
+	stderr := &limitedBuffer{max: c.opts.MaxOutputBytes}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+
+	var stdin *stickyReader
+	if c.opts.Input != InputNone {
+		obj, err := handler.Open(ctx, c.sess, ev)
+		if err != nil {
+			return nil, err
+		}
+		defer obj.Body.Close()
+		if c.opts.Input == InputStdin {
+			stdin = &stickyReader{r: obj.Body}
+			cmd.Stdin = stdin
+		} else {
+			path, err := spool(obj.Body)
+			if err != nil {
+				return nil, s3.Classify(err)
+			}
+			defer os.Remove(path)
+			cmd.Env = append(cmd.Env, "OBJECT_FILE="+path)
+		}
+	}
+
+	err = cmd.Run()
+	res := &handler.Result{Details: map[string]string{
+		"exitCode": strconv.Itoa(cmd.ProcessState.ExitCode()),
+		"stdout":   stdout.String(),
+		"stderr":   stderr.String(),
+	}}
+	// Commands need not read all of stdin, and a download that fails its
+	// checks must not be acked, so the rest is read before the exit code
+	// counts.
+	if stdin != nil {
+		if derr := handler.Drain(stdin); derr != nil {
+			return res, derr
+		}
+	}
+	if err == nil {
+		return res, nil
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return res, retry.Classify(retry.Requeue, fmt.Errorf("command timed out after %ds", c.opts.TimeoutSeconds))
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		// The command could not be started, e.g. a missing executable.
+		return res, retry.Classify(retry.Requeue, err)
+	}
+	for _, code := range c.opts.RetryExitCodes {
+		if exitErr.ExitCode() == code {
+			return res, retry.Classify(retry.Requeue, err)
+		}
+	}
+	return res, retry.Classify(retry.DeadLetter, err)
+}
+
+// spool writes the object to a temporary file for commands that need a path.
+func spool(r io.Reader) (string, error) {
+	f, err := os.CreateTemp("", "object-*")
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), f.Close()
+}
+
+// stickyReader keeps returning the first read error, which exec drops when
+// the command has exited without reading its input.
+type stickyReader struct {
+	r   io.Reader
+	err error
+}
+
+func (s *stickyReader) Read(p []byte) (int, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	n, err := s.r.Read(p)
+	s.err = err
+	return n, err
+}
+
+// limitedBuffer keeps the first max bytes written and discards the rest.
+type limitedBuffer struct {
+	buf       []byte
+	max       int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - len(b.buf); room < len(p) {
+		b.buf = append(b.buf, p[:maxInt(room, 0)]...)
+		b.truncated = true
+	} else {
+		b.buf = append(b.buf, p...)
+	}
+	return len(p), nil
+}
+
+func (b *limitedBuffer) String() string {
+	if b.truncated {
+		return string(b.buf) + "\n[truncated]"
+	}
+	return string(b.buf)
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
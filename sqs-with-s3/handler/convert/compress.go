@@ -0,0 +1,27 @@
+package convert
+
+import (
+	"compress/gzip"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// compressor wraps w with the named compression.
+func compressor(w io.Writer, format string) (io.WriteCloser, error) {
+	if format == "zstd" {
+		return zstd.NewWriter(w)
+	}
+	return gzip.NewWriter(w), nil
+}
+
+// trimCompressionExt drops a .gz, .gzip or .zst extension from key.
+func trimCompressionExt(key string) string {
+	for _, ext := range []string{".gz", ".gzip", ".zst"} {
+		if strings.HasSuffix(key, ext) {
+			return strings.TrimSuffix(key, ext)
+		}
+	}
+	return key
+}
@@ -0,0 +1,244 @@
+package convert
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/vubon/aws-examples/sqs-with-s3/handler"
+	"github.com/vubon/aws-examples/sqs-with-s3/handler/csvvalidate"
+	"github.com/vubon/aws-examples/sqs-with-s3/retry"
+	"github.com/vubon/aws-examples/sqs-with-s3/s3"
+)
+
+// formats maps each output format to its extension and content type.
+var formats = map[string]struct{ ext, contentType string }{
+	"parquet": {".parquet", "application/vnd.apache.parquet"},
+	"jsonl":   {".jsonl", "application/x-ndjson"},
+	"gzip":    {".gz", "application/gzip"},
+	"zstd":    {".zst", "application/zstd"},
+}
+
+// Options configure a convert handler.
+type Options struct {
+	// To is parquet or jsonl for CSV input, or gzip or zstd to recompress.
+	To string `json:"to"`
+	// TargetBucket receives the output, the source bucket by default.
+	TargetBucket string `json:"targetBucket"`
+	// TargetPrefix is prepended to the output key, "converted/" by default.
+	TargetPrefix string `json:"targetPrefix"`
+	// Schema is an optional csv-validate schema file giving the column types.
+	// Without it parquet types are inferred and JSONL values are strings.
+	Schema string `json:"schema"`
+	// Delimiter of the CSV input when there is no schema, "," by default.
+	Delimiter string `json:"delimiter"`
+}
+
+type Converter struct {
+	sess   *session.Session
+	opts   Options
+	schema *csvvalidate.Schema
+	comma  rune
+}
+
+// New creates a convert handler. It implements handler.Factory.
+func New(sess *session.Session, options json.RawMessage) (handler.Handler, error) {
+	c := &Converter{sess: sess, comma: ','}
+	if err := json.Unmarshal(options, &c.opts); err != nil {
+		return nil, err
+	}
+	if _, ok := formats[c.opts.To]; !ok {
+		return nil, fmt.Errorf("convert: unknown output format %q", c.opts.To)
+	}
+	if c.opts.TargetPrefix == "" {
+		c.opts.TargetPrefix = "converted/"
+	}
+	if c.opts.Delimiter != "" {
+		if utf8.RuneCountInString(c.opts.Delimiter) != 1 {
+			return nil, fmt.Errorf("convert: delimiter must be one character")
+		}
+		c.comma, _ = utf8.DecodeRuneInString(c.opts.Delimiter)
+	}
+	if c.opts.Schema != "" {
+		schema, err := csvvalidate.LoadSchema(c.opts.Schema)
+		if err != nil {
+			return nil, err
+		}
+		c.schema = schema
+		c.comma = schema.Comma()
+	}
+	return c, nil
+}
+
+// targetKey replaces the extensions of key with the one of the output format.
+func (c *Converter) targetKey(key string) string {
+	base := trimCompressionExt(key)
+	if c.opts.To == "parquet" || c.opts.To == "jsonl" {
+		base = strings.TrimSuffix(base, ".csv")
+	}
+	return c.opts.TargetPrefix + base + formats[c.opts.To].ext
+}
+
+// Handle converts the object and uploads the result with a streaming multipart upload.
+func (c *Converter) Handle(ctx context.Context, ev *handler.Event) (*handler.Result, error) {
+	bucket := c.opts.TargetBucket
+	if bucket == "" {
+		bucket = ev.Bucket
+	}
+	if ev.IsOutput(bucket, c.opts.TargetPrefix) {
+		return nil, nil
+	}
+
+	obj, err := handler.Open(ctx, c.sess, ev)
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Body.Close()
+	body := obj.Body
+
+	var write func(w io.Writer) error
+	switch c.opts.To {
+	case "parquet":
+		write = func(w io.Writer) error { return c.toParquet(w, body) }
+	case "jsonl":
+		write = func(w io.Writer) error { return c.toJSONL(w, body) }
+	default:
+		write = func(w io.Writer) error {
+			zw, err := compressor(w, c.opts.To)
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(zw, body); err != nil {
+				return err
+			}
+			return zw.Close()
+		}
+	}
+
+	location, err := upload(ctx, c.sess, bucket, c.targetKey(ev.Path()), formats[c.opts.To].contentType, write)
+	if err != nil {
+		return nil, err
+	}
+	return &handler.Result{Output: location}, nil
+}
+
+// upload streams what write produces to S3 through a pipe. An error of
+// write, e.g. malformed input, is returned in preference to the upload error
+// it causes, so that it keeps its retry class.
+func upload(ctx context.Context, sess *session.Session, bucket, key, contentType string, write func(w io.Writer) error) (string, error) {
+	pr, pw := io.Pipe()
+	written := make(chan error, 1)
+	go func() {
+		err := write(pw)
+		pw.CloseWithError(err)
+		written <- err
+	}()
+	location, err := s3.Upload(ctx, sess, bucket, key, contentType, pr)
+	// Unblock the writer if the upload stopped reading early.
+	pr.CloseWithError(err)
+	if werr := <-written; werr != nil && (err == nil || !errors.Is(werr, err)) {
+		return "", werr
+	}
+	return location, err
+}
+
+func (c *Converter) reader(r io.Reader) (*csv.Reader, []string, error) {
+	reader := csv.NewReader(r)
+	reader.Comma = c.comma
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, retry.Classify(retry.DeadLetter, fmt.Errorf("read csv header: %w", err))
+	}
+	reader.ReuseRecord = true
+	return reader, header, nil
+}
+
+// toParquet converts CSV to parquet. Inferring the schema reads the data
+// twice, so the CSV is spooled to a temporary file first.
+func (c *Converter) toParquet(w io.Writer, r io.Reader) error {
+	if c.schema != nil {
+		reader, header, err := c.reader(r)
+		if err != nil {
+			return err
+		}
+		columns, err := columnsFromSchema(c.schema, header)
+		if err != nil {
+			return retry.Classify(retry.DeadLetter, err)
+		}
+		return writeParquet(w, reader, columns)
+	}
+
+	spool, err := os.CreateTemp("", "convert-*.csv")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+	reader, header, err := c.reader(io.TeeReader(r, spool))
+	if err != nil {
+		return err
+	}
+	columns, err := inferColumns(reader, header)
+	if err != nil {
+		return err
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	reader, _, err = c.reader(spool)
+	if err != nil {
+		return err
+	}
+	return writeParquet(w, reader, columns)
+}
+
+// toJSONL writes one JSON object per CSV record, keyed by the header.
+func (c *Converter) toJSONL(w io.Writer, r io.Reader) error {
+	reader, header, err := c.reader(r)
+	if err != nil {
+		return err
+	}
+	var columns []*column
+	if c.schema != nil {
+		if columns, err = columnsFromSchema(c.schema, header); err != nil {
+			return retry.Classify(retry.DeadLetter, err)
+		}
+	}
+	enc := json.NewEncoder(w)
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return retry.Classify(retry.DeadLetter, err)
+		}
+		row := make(map[string]interface{}, len(header))
+		for i, name := range header {
+			if i >= len(record) {
+				row[name] = nil
+				continue
+			}
+			if columns == nil {
+				row[name] = record[i]
+				continue
+			}
+			v, err := columns[i].jsonValue(strings.TrimSpace(record[i]))
+			if err != nil {
+				return retry.Classify(retry.DeadLetter, fmt.Errorf("line %d column %s: %w", line, name, err))
+			}
+			row[name] = v
+		}
+		if err := enc.Encode(row); err != nil {
+			return err
+		}
+	}
+}
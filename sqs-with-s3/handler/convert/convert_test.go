@@ -0,0 +1,77 @@
+package convert
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/vubon/aws-examples/sqs-with-s3/handler"
+	"github.com/vubon/aws-examples/sqs-with-s3/retry"
+	"github.com/vubon/aws-examples/sqs-with-s3/s3test"
+)
+
+func TestHandle(t *testing.T) {
+	tests := []struct {
+		name   string
+		to     string
+		csv    string
+		output string
+		class  retry.Class
+		want   string
+	}{
+		{"jsonl", "jsonl", "id,name\n1,ann\n2,bob\n", "converted/data.jsonl", -1, "{\"id\":\"1\",\"name\":\"ann\"}\n{\"id\":\"2\",\"name\":\"bob\"}\n"},
+		{"malformed jsonl", "jsonl", "id,name\n1,a\"nn\n", "", retry.DeadLetter, ""},
+		{"malformed parquet", "parquet", "id,name\n1,ann\n2,b\"ob\n", "", retry.DeadLetter, ""},
+		{"empty", "jsonl", "", "", retry.DeadLetter, ""},
+		{"gzip", "gzip", "id\n1\n", "converted/data.csv.gz", -1, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := s3test.NewServer(t)
+			srv.Put("src", "data.csv", &s3test.Object{Body: []byte(tt.csv)})
+			options, _ := json.Marshal(map[string]string{"to": tt.to})
+			h, err := New(srv.Session(), options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ev := &handler.Event{EventName: "ObjectCreated:Put", Bucket: "src", Key: "data.csv", Size: int64(len(tt.csv))}
+			res, err := h.Handle(context.Background(), ev)
+			if tt.class >= 0 {
+				if err == nil || retry.ClassOf(err) != tt.class {
+					t.Fatalf("got %v, want class %v", err, tt.class)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Output != "s3://src/"+tt.output {
+				t.Errorf("output %s, want s3://src/%s", res.Output, tt.output)
+			}
+			obj := srv.Get("src", tt.output)
+			if obj == nil {
+				t.Fatal("no output object")
+			}
+			if tt.want != "" && string(obj.Body) != tt.want {
+				t.Errorf("output %q, want %q", obj.Body, tt.want)
+			}
+		})
+	}
+}
+
+func TestTargetKey(t *testing.T) {
+	tests := []struct {
+		to, key, want string
+	}{
+		{"parquet", "in/a.csv", "converted/in/a.parquet"},
+		{"jsonl", "in/a.csv.gz", "converted/in/a.jsonl"},
+		{"zstd", "in/a.log.gz", "converted/in/a.log.zst"},
+		{"gzip", "in/a.txt", "converted/in/a.txt.gz"},
+	}
+	for _, tt := range tests {
+		c := &Converter{opts: Options{To: tt.to, TargetPrefix: "converted/"}}
+		if got := c.targetKey(tt.key); got != tt.want {
+			t.Errorf("targetKey(%q) to %s = %q, want %q", tt.key, tt.to, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,290 @@
+package convert
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/vubon/aws-examples/sqs-with-s3/handler/csvvalidate"
+	"github.com/vubon/aws-examples/sqs-with-s3/retry"
+)
+
+// columnType is the parquet type of a CSV column. Inference widens int to
+// float to string as values require.
+type columnType int
+
+const (
+	typeUnknown columnType = iota
+	typeBool
+	typeInt
+	typeFloat
+	typeDate
+	typeDatetime
+	typeString
+)
+
+type column struct {
+	name   string
+	typ    columnType
+	format string
+}
+
+func (c *column) node() parquet.Node {
+	var node parquet.Node
+	switch c.typ {
+	case typeBool:
+		node = parquet.Leaf(parquet.BooleanType)
+	case typeInt:
+		node = parquet.Int(64)
+	case typeFloat:
+		node = parquet.Leaf(parquet.DoubleType)
+	case typeDate:
+		node = parquet.Date()
+	case typeDatetime:
+		node = parquet.Timestamp(parquet.Millisecond)
+	default:
+		node = parquet.String()
+	}
+	return parquet.Optional(node)
+}
+
+// value converts a CSV field. Empty fields are null.
+func (c *column) value(field string, columnIndex int) (parquet.Value, error) {
+	if field == "" {
+		return parquet.NullValue().Level(0, 0, columnIndex), nil
+	}
+	var v parquet.Value
+	switch c.typ {
+	case typeBool:
+		b, err := strconv.ParseBool(strings.ToLower(field))
+		if err != nil {
+			return v, err
+		}
+		v = parquet.BooleanValue(b)
+	case typeInt:
+		n, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			return v, err
+		}
+		v = parquet.Int64Value(n)
+	case typeFloat:
+		f, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			return v, err
+		}
+		v = parquet.DoubleValue(f)
+	case typeDate:
+		t, err := time.Parse(c.format, field)
+		if err != nil {
+			return v, err
+		}
+		v = parquet.Int32Value(int32(t.Unix() / 86400))
+	case typeDatetime:
+		t, err := time.Parse(c.format, field)
+		if err != nil {
+			return v, err
+		}
+		v = parquet.Int64Value(t.UnixMilli())
+	default:
+		v = parquet.ByteArrayValue([]byte(field))
+	}
+	return v.Level(0, 1, columnIndex), nil
+}
+
+// observe widens an inferred column type to fit field.
+func (c *column) observe(field string) {
+	if field == "" {
+		return
+	}
+	switch c.typ {
+	case typeUnknown:
+		c.typ = inferType(field)
+	case typeBool:
+		if !isBool(field) {
+			c.typ = typeString
+		}
+	case typeInt:
+		if !isInt(field) {
+			c.typ = typeString
+			if isFloat(field) {
+				c.typ = typeFloat
+			}
+		}
+	case typeFloat:
+		if !isFloat(field) {
+			c.typ = typeString
+		}
+	}
+}
+
+func inferType(field string) columnType {
+	switch {
+	case isInt(field):
+		return typeInt
+	case isFloat(field):
+		return typeFloat
+	case isBool(field):
+		return typeBool
+	}
+	return typeString
+}
+
+func isInt(s string) bool {
+	_, err := strconv.ParseInt(s, 10, 64)
+	return err == nil
+}
+
+func isFloat(s string) bool {
+	_, err := strconv.ParseFloat(s, 64)
+	return err == nil
+}
+
+// isBool only accepts true and false; strconv.ParseBool would also take 1 and 0.
+func isBool(s string) bool {
+	return strings.EqualFold(s, "true") || strings.EqualFold(s, "false")
+}
+
+// columnsFromSchema takes the column types of an explicit csv-validate schema.
+func columnsFromSchema(schema *csvvalidate.Schema, header []string) ([]*column, error) {
+	byName := map[string]csvvalidate.Column{}
+	for _, c := range schema.Columns {
+		byName[c.Name] = c
+	}
+	columns := make([]*column, len(header))
+	for i, name := range header {
+		sc, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("column %s is not in the schema", name)
+		}
+		c := &column{name: name, format: sc.Format}
+		switch sc.Type {
+		case "bool":
+			c.typ = typeBool
+		case "int":
+			c.typ = typeInt
+		case "float":
+			c.typ = typeFloat
+		case "date":
+			c.typ = typeDate
+		case "datetime":
+			c.typ = typeDatetime
+		default:
+			c.typ = typeString
+		}
+		columns[i] = c
+	}
+	return columns, nil
+}
+
+// inferColumns reads every record of r and picks the narrowest type for each column.
+func inferColumns(r *csv.Reader, header []string) ([]*column, error) {
+	columns := make([]*column, len(header))
+	for i, name := range header {
+		columns[i] = &column{name: name}
+	}
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, retry.Classify(retry.DeadLetter, err)
+		}
+		for i, field := range record {
+			if i < len(columns) {
+				columns[i].observe(strings.TrimSpace(field))
+			}
+		}
+	}
+	for _, c := range columns {
+		if c.typ == typeUnknown {
+			c.typ = typeString
+		}
+	}
+	return columns, nil
+}
+
+// writeParquet converts the records of r to parquet on w.
+func writeParquet(w io.Writer, r *csv.Reader, columns []*column) error {
+	group := parquet.Group{}
+	for _, c := range columns {
+		if _, ok := group[c.name]; ok {
+			return retry.Classify(retry.DeadLetter, fmt.Errorf("duplicate column %s", c.name))
+		}
+		group[c.name] = c.node()
+	}
+	schema := parquet.NewSchema("csv", group)
+	// Parquet orders the columns of a group by name.
+	index := make([]int, len(columns))
+	for i, c := range columns {
+		leaf, ok := schema.Lookup(c.name)
+		if !ok {
+			return fmt.Errorf("column %s missing from parquet schema", c.name)
+		}
+		index[i] = leaf.ColumnIndex
+	}
+
+	writer := parquet.NewWriter(w, schema, parquet.Compression(&parquet.Snappy))
+	rows := make([]parquet.Row, 0, 1000)
+	flush := func() error {
+		_, err := writer.WriteRows(rows)
+		rows = rows[:0]
+		return err
+	}
+	for line := 2; ; line++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return retry.Classify(retry.DeadLetter, err)
+		}
+		row := make(parquet.Row, len(columns))
+		for i, c := range columns {
+			field := ""
+			if i < len(record) {
+				field = record[i]
+			}
+			v, err := c.value(strings.TrimSpace(field), index[i])
+			if err != nil {
+				return retry.Classify(retry.DeadLetter, fmt.Errorf("line %d column %s: %w", line, c.name, err))
+			}
+			row[index[i]] = v
+		}
+		rows = append(rows, row)
+		if len(rows) == cap(rows) {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	return writer.Close()
+}
+
+// jsonValue converts a CSV field for JSON output. Empty fields are null and
+// dates keep their text.
+func (c *column) jsonValue(field string) (interface{}, error) {
+	if field == "" {
+		return nil, nil
+	}
+	switch c.typ {
+	case typeBool:
+		return strconv.ParseBool(strings.ToLower(field))
+	case typeInt:
+		return strconv.ParseInt(field, 10, 64)
+	case typeFloat:
+		return strconv.ParseFloat(field, 64)
+	case typeDate, typeDatetime:
+		if _, err := time.Parse(c.format, field); err != nil {
+			return nil, err
+		}
+	}
+	return field, nil
+}
@@ -0,0 +1,257 @@
+package csvvalidate
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/vubon/aws-examples/sqs-with-s3/handler"
+	"github.com/vubon/aws-examples/sqs-with-s3/retry"
+	"github.com/vubon/aws-examples/sqs-with-s3/s3"
+)
+
+const (
+	StatusPassed = "passed"
+	StatusFailed = "failed"
+)
+
+// Options configure a csv-validate handler.
+type Options struct {
+	// Schema is the path of the schema file.
+	Schema string `json:"schema"`
+	// ReportBucket receives the reports, the source bucket by default.
+	ReportBucket string `json:"reportBucket"`
+	// ReportPrefix is prepended to the source key of the reports, "validation/" by default.
+	ReportPrefix string `json:"reportPrefix"`
+	// MaxErrors caps the errors listed in the JSON report, 1000 by default.
+	// Every rejected row is still written to the rejected rows CSV.
+	MaxErrors int `json:"maxErrors"`
+}
+
+// Error is one problem found in the object. Row 0 is the header.
+type Error struct {
+	Row     int    `json:"row"`
+	Column  string `json:"column,omitempty"`
+	Value   string `json:"value,omitempty"`
+	Message string `json:"message"`
+}
+
+// Report is written next to the rejected rows after every validation.
+type Report struct {
+	Bucket       string  `json:"bucket"`
+	Key          string  `json:"key"`
+	VersionID    string  `json:"versionId,omitempty"`
+	Entry        string  `json:"entry,omitempty"`
+	Schema       string  `json:"schema"`
+	Valid        bool    `json:"valid"`
+	Rows         int     `json:"rows"`
+	RejectedRows int     `json:"rejectedRows"`
+	Errors       []Error `json:"errors"`
+	// Truncated is set when more errors were found than MaxErrors.
+	Truncated bool   `json:"truncated,omitempty"`
+	Rejected  string `json:"rejected,omitempty"`
+}
+
+type Validator struct {
+	sess   *session.Session
+	opts   Options
+	schema *Schema
+}
+
+// New creates a csv-validate handler. It implements handler.Factory.
+func New(sess *session.Session, options json.RawMessage) (handler.Handler, error) {
+	v := &Validator{sess: sess}
+	if err := json.Unmarshal(options, &v.opts); err != nil {
+		return nil, err
+	}
+	if v.opts.Schema == "" {
+		return nil, errors.New("csv-validate needs a schema")
+	}
+	if v.opts.ReportPrefix == "" {
+		v.opts.ReportPrefix = "validation/"
+	}
+	if v.opts.MaxErrors <= 0 {
+		v.opts.MaxErrors = 1000
+	}
+	schema, err := LoadSchema(v.opts.Schema)
+	if err != nil {
+		return nil, err
+	}
+	v.schema = schema
+	return v, nil
+}
+
+// Handle streams the object through the schema and writes the report. A
+// rejected object is a successful run with the status "failed".
+func (v *Validator) Handle(ctx context.Context, ev *handler.Event) (*handler.Result, error) {
+	reportBucket := v.opts.ReportBucket
+	if reportBucket == "" {
+		reportBucket = ev.Bucket
+	}
+	if ev.IsOutput(reportBucket, v.opts.ReportPrefix) {
+		return nil, nil
+	}
+
+	obj, err := handler.Open(ctx, v.sess, ev)
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Body.Close()
+
+	rejected, err := os.CreateTemp("", "rejected-*.csv")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(rejected.Name())
+	defer rejected.Close()
+
+	report := &Report{Bucket: ev.Bucket, Key: ev.Key, VersionID: ev.VersionID, Entry: ev.Entry, Schema: v.opts.Schema, Errors: []Error{}}
+	if err := v.validate(obj.Body, rejected, report); err != nil {
+		return nil, err
+	}
+	report.Valid = report.RejectedRows == 0 && len(report.Errors) == 0
+
+	base := v.opts.ReportPrefix + ev.Path()
+	if report.RejectedRows > 0 {
+		if _, err := rejected.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		report.Rejected, err = s3.Upload(ctx, v.sess, reportBucket, base+".rejected.csv", "text/csv", rejected)
+		if err != nil {
+			return nil, err
+		}
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	location, err := s3.Upload(ctx, v.sess, reportBucket, base+".report.json", "application/json", bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	status := StatusPassed
+	if !report.Valid {
+		status = StatusFailed
+	}
+	return &handler.Result{Output: location, Status: status}, nil
+}
+
+func (v *Validator) addError(report *Report, e Error) {
+	if len(report.Errors) >= v.opts.MaxErrors {
+		report.Truncated = true
+		return
+	}
+	report.Errors = append(report.Errors, e)
+}
+
+// validate checks the header and every row, writing rejected rows to w with
+// an extra _errors column.
+func (v *Validator) validate(r io.Reader, w io.Writer, report *Report) error {
+	reader := csv.NewReader(r)
+	reader.Comma = v.schema.comma
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		v.addError(report, Error{Message: "empty file"})
+		return nil
+	}
+	if err != nil {
+		return retry.Classify(retry.DeadLetter, fmt.Errorf("read csv header: %w", err))
+	}
+	header = append([]string(nil), header...)
+
+	// index maps each schema column to its position in the header.
+	index := make([]int, len(v.schema.Columns))
+	for i, col := range v.schema.Columns {
+		index[i] = indexOf(header, col.Name)
+		if index[i] < 0 {
+			v.addError(report, Error{Column: col.Name, Message: "missing column"})
+		}
+	}
+	if !v.schema.AllowExtraColumns {
+		for _, name := range header {
+			if v.schema.column(name) == nil {
+				v.addError(report, Error{Column: name, Message: "unexpected column"})
+			}
+		}
+	}
+	if len(report.Errors) > 0 {
+		// Rows cannot be checked against a header that does not fit.
+		return nil
+	}
+
+	out := csv.NewWriter(w)
+	out.Comma = v.schema.comma
+	if err := out.Write(append(header, "_errors")); err != nil {
+		return err
+	}
+	for row := 1; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				report.Rows++
+				report.RejectedRows++
+				v.addError(report, Error{Row: row, Message: parseErr.Err.Error()})
+				continue
+			}
+			return err
+		}
+		report.Rows++
+
+		var problems []string
+		if len(record) != len(header) {
+			problems = append(problems, fmt.Sprintf("has %d fields, header has %d", len(record), len(header)))
+			v.addError(report, Error{Row: row, Message: problems[0]})
+		}
+		for i, col := range v.schema.Columns {
+			value := ""
+			if index[i] < len(record) {
+				value = record[index[i]]
+			}
+			if msg := col.check(value); msg != "" {
+				problems = append(problems, col.Name+": "+msg)
+				v.addError(report, Error{Row: row, Column: col.Name, Value: value, Message: msg})
+			}
+		}
+		if len(problems) > 0 {
+			report.RejectedRows++
+			if err := out.Write(append(record, strings.Join(problems, "; "))); err != nil {
+				return err
+			}
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
+
+func (s *Schema) column(name string) *Column {
+	for i := range s.Columns {
+		if s.Columns[i].Name == name {
+			return &s.Columns[i]
+		}
+	}
+	return nil
+}
+
+func indexOf(list []string, s string) int {
+	for i, v := range list {
+		if v == s {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,117 @@
+package csvvalidate
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/vubon/aws-examples/sqs-with-s3/handler"
+	"github.com/vubon/aws-examples/sqs-with-s3/s3test"
+)
+
+const testSchema = `{"columns": [
+	{"name": "id", "type": "int", "required": true},
+	{"name": "status", "enum": ["new", "done"]}
+]}`
+
+func TestHandle(t *testing.T) {
+	tests := []struct {
+		name     string
+		key      string
+		csv      string
+		status   string
+		errors   []Error
+		rejected string
+	}{
+		{"valid", "in.csv", "id,status\n1,new\n2,\n", StatusPassed, []Error{}, ""},
+		{"bad values", "in.csv", "id,status\n1,new\nx,old\n,done\n", StatusFailed, []Error{
+			{Row: 2, Column: "id", Value: "x", Message: "not a valid int"},
+			{Row: 2, Column: "status", Value: "old", Message: "not one of new, done"},
+			{Row: 3, Column: "id", Message: "required value is empty"},
+		}, "id,status,_errors\nx,old,\"id: not a valid int; status: not one of new, done\"\n,done,id: required value is empty\n"},
+		{"short row", "in.csv", "id,status\n1\n", StatusFailed, []Error{
+			{Row: 1, Message: "has 1 fields, header has 2"},
+		}, "id,status,_errors\n1,\"has 1 fields, header has 2\"\n"},
+		{"header", "in.csv", "id,owner\n1,ann\n", StatusFailed, []Error{
+			{Column: "status", Message: "missing column"},
+			{Column: "owner", Message: "unexpected column"},
+		}, ""},
+		{"empty", "in.csv", "", StatusFailed, []Error{{Message: "empty file"}}, ""},
+		{"report", "validation/in.csv.report.json", "", "", nil, ""},
+	}
+	schema := filepath.Join(t.TempDir(), "schema.json")
+	if err := os.WriteFile(schema, []byte(testSchema), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := s3test.NewServer(t)
+			srv.Put("src", tt.key, &s3test.Object{Body: []byte(tt.csv)})
+			options, _ := json.Marshal(Options{Schema: schema})
+			h, err := New(srv.Session(), options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ev := &handler.Event{EventName: "ObjectCreated:Put", Bucket: "src", Key: tt.key, Size: int64(len(tt.csv))}
+			res, err := h.Handle(context.Background(), ev)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.errors == nil {
+				if res != nil || len(srv.Ops()) != 0 {
+					t.Fatalf("handled its own report: %v, %v", res, srv.Ops())
+				}
+				return
+			}
+			if res.Status != tt.status || res.Output != "s3://src/validation/in.csv.report.json" {
+				t.Errorf("status %s output %s, want %s and the report", res.Status, res.Output, tt.status)
+			}
+			var report Report
+			if err := json.Unmarshal(srv.Get("src", "validation/in.csv.report.json").Body, &report); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(report.Errors, tt.errors) {
+				t.Errorf("errors %+v, want %+v", report.Errors, tt.errors)
+			}
+			rejected := srv.Get("src", "validation/in.csv.rejected.csv")
+			if tt.rejected == "" {
+				if rejected != nil || report.Rejected != "" {
+					t.Errorf("rejected rows written for %q", tt.csv)
+				}
+				return
+			}
+			if rejected == nil {
+				t.Fatal("no rejected rows written")
+			}
+			if string(rejected.Body) != tt.rejected {
+				t.Errorf("rejected rows %q, want %q", rejected.Body, tt.rejected)
+			}
+		})
+	}
+}
+
+func TestColumnCheck(t *testing.T) {
+	tests := []struct {
+		column Column
+		value  string
+		want   string
+	}{
+		{Column{Type: "string"}, "", ""},
+		{Column{Type: "string", Required: true}, " ", "required value is empty"},
+		{Column{Type: "int"}, "12", ""},
+		{Column{Type: "int"}, "1.5", "not a valid int"},
+		{Column{Type: "float"}, "1.5", ""},
+		{Column{Type: "bool"}, "yes", "not a valid bool"},
+		{Column{Type: "date", Format: "2006-01-02"}, "2024-02-30", "not a valid date"},
+		{Column{Type: "datetime", Format: "2006-01-02T15:04:05Z07:00"}, "2024-01-01T00:00:00Z", ""},
+		{Column{Type: "string", Enum: []string{"a", "b"}}, "c", "not one of a, b"},
+	}
+	for _, tt := range tests {
+		if got := tt.column.check(tt.value); got != tt.want {
+			t.Errorf("%s column check(%q) = %q, want %q", tt.column.Type, tt.value, got, tt.want)
+		}
+	}
+}
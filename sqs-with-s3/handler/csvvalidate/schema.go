@@ -0,0 +1,129 @@
+package csvvalidate
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Schema describes the columns a CSV object must have.
+type Schema struct {
+	// Delimiter is a single character, "," by default.
+	Delimiter string `json:"delimiter"`
+	// AllowExtraColumns accepts header columns the schema does not list.
+	AllowExtraColumns bool     `json:"allowExtraColumns"`
+	Columns           []Column `json:"columns"`
+
+	comma rune
+}
+
+// Column constrains the values of one named column.
+type Column struct {
+	Name string `json:"name"`
+	// Type is string (default), int, float, bool, date or datetime.
+	Type     string `json:"type"`
+	Required bool   `json:"required"`
+	// Pattern is a regular expression the whole value must match.
+	Pattern string   `json:"pattern"`
+	Enum    []string `json:"enum"`
+	// Format is the Go time layout of date (2006-01-02) and datetime (RFC 3339) values.
+	Format string `json:"format"`
+
+	re *regexp.Regexp
+}
+
+// LoadSchema reads and checks a schema file.
+func LoadSchema(path string) (*Schema, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &Schema{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("schema %s: %w", path, err)
+	}
+	s.comma = ','
+	if s.Delimiter != "" {
+		if utf8.RuneCountInString(s.Delimiter) != 1 {
+			return nil, fmt.Errorf("schema %s: delimiter must be one character", path)
+		}
+		s.comma, _ = utf8.DecodeRuneInString(s.Delimiter)
+	}
+	if len(s.Columns) == 0 {
+		return nil, fmt.Errorf("schema %s: no columns", path)
+	}
+	for i := range s.Columns {
+		c := &s.Columns[i]
+		switch c.Type {
+		case "":
+			c.Type = "string"
+		case "string", "int", "float", "bool":
+		case "date":
+			if c.Format == "" {
+				c.Format = "2006-01-02"
+			}
+		case "datetime":
+			if c.Format == "" {
+				c.Format = time.RFC3339
+			}
+		default:
+			return nil, fmt.Errorf("schema %s: column %s has unknown type %q", path, c.Name, c.Type)
+		}
+		if c.Pattern != "" {
+			if c.re, err = regexp.Compile("^(?:" + c.Pattern + ")$"); err != nil {
+				return nil, fmt.Errorf("schema %s: column %s: %w", path, c.Name, err)
+			}
+		}
+	}
+	return s, nil
+}
+
+// check returns why value does not fit the column, or "" when it does.
+func (c *Column) check(value string) string {
+	if strings.TrimSpace(value) == "" {
+		if c.Required {
+			return "required value is empty"
+		}
+		return ""
+	}
+	var err error
+	switch c.Type {
+	case "int":
+		_, err = strconv.ParseInt(value, 10, 64)
+	case "float":
+		_, err = strconv.ParseFloat(value, 64)
+	case "bool":
+		_, err = strconv.ParseBool(value)
+	case "date", "datetime":
+		_, err = time.Parse(c.Format, value)
+	}
+	if err != nil {
+		return "not a valid " + c.Type
+	}
+	if c.re != nil && !c.re.MatchString(value) {
+		return "does not match pattern " + c.Pattern
+	}
+	if len(c.Enum) > 0 && !contains(c.Enum, value) {
+		return "not one of " + strings.Join(c.Enum, ", ")
+	}
+	return ""
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Comma returns the delimiter of the schema.
+func (s *Schema) Comma() rune {
+	return s.comma
+}
@@ -0,0 +1,167 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/vubon/aws-examples/sqs-with-s3/config"
+	"github.com/vubon/aws-examples/sqs-with-s3/s3"
+)
+
+// Event is one normalised S3 event record taken from an SQS message.
+type Event struct {
+	MessageID string    `json:"messageId"`
+	Queue     string    `json:"queue"`
+	EventName string    `json:"eventName"`
+	EventTime time.Time `json:"eventTime"`
+	Region    string    `json:"region"`
+	Bucket    string    `json:"bucket"`
+	Key       string    `json:"key"`
+	Size      int64     `json:"size"`
+	ETag      string    `json:"eTag"`
+	// VersionID is set for events from versioned buckets. For delete marker
+	// events it is the version of the marker.
+	VersionID string `json:"versionId,omitempty"`
+	Sequencer string `json:"sequencer"`
+	// Entry is the path inside the archive Key when the handler is given
+	// one file of an expanded archive.
+	Entry string `json:"entry,omitempty"`
+
+	entry *s3.Entry
+}
+
+// Result describes what a handler produced.
+type Result struct {
+	// Output is the location of anything the handler wrote, e.g. s3://bucket/key.
+	Output string
+	// Status replaces "success" in the outcome, e.g. "passed" or "failed"
+	// for a validation that ran but rejected the object.
+	Status string
+	// Details are copied into the outcome, also when the handler fails.
+	Details map[string]string
+}
+
+// Handler processes one event. Errors can be classified with the retry
+// package to choose between requeueing and dead-lettering the message.
+type Handler interface {
+	Handle(ctx context.Context, ev *Event) (*Result, error)
+}
+
+// Func adapts a function to a Handler.
+type Func func(ctx context.Context, ev *Event) (*Result, error)
+
+func (f Func) Handle(ctx context.Context, ev *Event) (*Result, error) {
+	return f(ctx, ev)
+}
+
+// Factory creates a handler from the JSON options of a configured instance.
+type Factory func(sess *session.Session, options json.RawMessage) (Handler, error)
+
+var (
+	mu        sync.RWMutex
+	registry  = map[string]Handler{}
+	factories = map[string]Factory{}
+)
+
+// RegisterType makes a handler type available to the handlers config.
+func RegisterType(typ string, f Factory) {
+	mu.Lock()
+	defer mu.Unlock()
+	factories[typ] = f
+}
+
+// Configure creates and registers the configured handler instances. Each
+// entry names its handler type with "type"; the other fields are options.
+func Configure(sess *session.Session, handlers map[string]json.RawMessage) error {
+	for name, options := range handlers {
+		var typ struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(options, &typ); err != nil {
+			return fmt.Errorf("handler %s: %w", name, err)
+		}
+		mu.RLock()
+		f, ok := factories[typ.Type]
+		mu.RUnlock()
+		if !ok {
+			return fmt.Errorf("handler %s: unknown type %q", name, typ.Type)
+		}
+		h, err := f(sess, options)
+		if err != nil {
+			return fmt.Errorf("handler %s: %w", name, err)
+		}
+		Register(name, h)
+	}
+	return nil
+}
+
+// Register makes a handler available to routes under name.
+func Register(name string, h Handler) {
+	mu.Lock()
+	defer mu.Unlock()
+	registry[name] = h
+}
+
+// Lookup returns the handler registered under name.
+func Lookup(name string) (Handler, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	h, ok := registry[name]
+	return h, ok
+}
+
+// Route is a configured route bound to its handler.
+type Route struct {
+	config.Route
+	Handler Handler
+}
+
+// Match reports whether the route applies to ev.
+func (r *Route) Match(ev *Event) bool {
+	if !strings.HasPrefix(ev.Key, r.KeyPrefix) || !strings.HasSuffix(ev.Key, r.KeySuffix) {
+		return false
+	}
+	if len(r.Events) == 0 {
+		return true
+	}
+	for _, name := range r.Events {
+		if strings.HasPrefix(ev.EventName, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Router sends each event to every matching route.
+type Router struct {
+	routes []*Route
+}
+
+// NewRouter binds routes to registered handlers.
+func NewRouter(routes []config.Route) (*Router, error) {
+	r := &Router{}
+	for _, rc := range routes {
+		h, ok := Lookup(rc.Handler)
+		if !ok {
+			return nil, fmt.Errorf("route: unknown handler %q", rc.Handler)
+		}
+		r.routes = append(r.routes, &Route{Route: rc, Handler: h})
+	}
+	return r, nil
+}
+
+// Routes returns the routes matching ev.
+func (r *Router) Routes(ev *Event) []*Route {
+	var matched []*Route
+	for _, route := range r.routes {
+		if route.Match(ev) {
+			matched = append(matched, route)
+		}
+	}
+	return matched
+}
@@ -0,0 +1,73 @@
+package mirror
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/vubon/aws-examples/sqs-with-s3/handler"
+	"github.com/vubon/aws-examples/sqs-with-s3/retry"
+	"github.com/vubon/aws-examples/sqs-with-s3/s3"
+)
+
+// Options configure a mirror handler.
+type Options struct {
+	// TargetBucket receives the copies. It should have versioning enabled to
+	// keep the version history of the source.
+	TargetBucket string `json:"targetBucket"`
+	// TargetPrefix is prepended to the key of every copy.
+	TargetPrefix string `json:"targetPrefix"`
+}
+
+type Mirror struct {
+	sess *session.Session
+	opts Options
+}
+
+// New creates a mirror handler. It implements handler.Factory.
+func New(sess *session.Session, options json.RawMessage) (handler.Handler, error) {
+	m := &Mirror{sess: sess}
+	if err := json.Unmarshal(options, &m.opts); err != nil {
+		return nil, err
+	}
+	if m.opts.TargetBucket == "" {
+		return nil, errors.New("mirror needs a targetBucket")
+	}
+	return m, nil
+}
+
+// Handle copies every created version to the target, and adds a delete
+// marker there for every delete marker of the source. Versions deleted
+// permanently from the source are kept in the target.
+func (m *Mirror) Handle(ctx context.Context, ev *handler.Event) (*handler.Result, error) {
+	if ev.Entry != "" {
+		return nil, retry.Classify(retry.DeadLetter, errors.New("mirror copies whole objects and does not expand archives"))
+	}
+	if ev.IsOutput(m.opts.TargetBucket, m.opts.TargetPrefix) {
+		return &handler.Result{Details: map[string]string{"skipped": "mirrored copy"}}, nil
+	}
+	key := m.opts.TargetPrefix + ev.Key
+	output := fmt.Sprintf("s3://%s/%s", m.opts.TargetBucket, key)
+	switch {
+	case strings.HasPrefix(ev.EventName, "ObjectCreated:"):
+		versionID, copied, err := s3.CopyVersion(ctx, m.sess, ev.Bucket, ev.Key, ev.VersionID, m.opts.TargetBucket, key)
+		if err != nil {
+			return nil, err
+		}
+		details := map[string]string{"targetVersionId": versionID}
+		if !copied {
+			details["skipped"] = "already mirrored"
+		}
+		return &handler.Result{Output: output, Details: details}, nil
+	case ev.DeleteMarker(), strings.HasPrefix(ev.EventName, "ObjectRemoved:") && ev.VersionID == "":
+		// Deleting without a version adds a delete marker to a versioned target.
+		if err := s3.Delete(ctx, m.sess, m.opts.TargetBucket, key, ""); err != nil {
+			return nil, err
+		}
+		return &handler.Result{Output: output}, nil
+	}
+	return &handler.Result{Details: map[string]string{"skipped": ev.EventName}}, nil
+}
@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/vubon/aws-examples/sqs-with-s3/retry"
+	"github.com/vubon/aws-examples/sqs-with-s3/s3"
+)
+
+// EventDeleteMarker is the event of a delete on a versioned bucket, which
+// adds a delete marker instead of removing data.
+const EventDeleteMarker = "ObjectRemoved:DeleteMarkerCreated"
+
+// ErrDeleteMarker is returned by Open for delete marker events.
+var ErrDeleteMarker = errors.New("event is a delete marker, there is no object to read")
+
+// DeleteMarker reports whether the event created a delete marker.
+func (ev *Event) DeleteMarker() bool {
+	return ev.EventName == EventDeleteMarker
+}
+
+// IsOutput reports whether ev is for an object written to bucket, or the
+// event's own bucket when it is empty, under prefix. Handlers and
+// post-actions writing to the source bucket raise events of their own, which
+// they skip instead of processing their output again.
+func (ev *Event) IsOutput(bucket, prefix string) bool {
+	return (bucket == "" || bucket == ev.Bucket) && strings.HasPrefix(ev.Key, prefix)
+}
+
+// ForEntry returns a copy of the event for one file of the archive it names.
+func (ev *Event) ForEntry(e *s3.Entry) *Event {
+	sub := *ev
+	sub.Entry = e.Name
+	sub.Size = e.Size
+	sub.ETag = ""
+	sub.entry = e
+	return &sub
+}
+
+// Path is the key of the object, followed by the entry path for archive
+// entries. Handlers derive output keys from it.
+func (ev *Event) Path() string {
+	if ev.Entry == "" {
+		return ev.Key
+	}
+	return ev.Key + "/" + ev.Entry
+}
+
+// Open returns the decompressed content of the event's object, or of the
+// archive entry for events made by ForEntry. The version named by the event
+// is read, so a newer upload of the key is never handled in its place; delete
+// markers have no content and are dead-lettered. Large objects are downloaded
+// with parallel ranged GETs when configured, and the download is verified
+// against the event with s3.Verify before it is decrypted (when client-side
+// encrypted) and decompressed. The caller closes the body. Decrypted content
+// is authenticated before Open returns; verification of other objects is
+// only complete once the body has returned io.EOF, so handlers must not act
+// on the content before then.
+func Open(ctx context.Context, sess *session.Session, ev *Event) (*s3.Object, error) {
+	if ev.entry != nil {
+		return &s3.Object{Body: io.NopCloser(ev.entry.Body), ContentLength: ev.entry.Size}, nil
+	}
+	if ev.DeleteMarker() {
+		return nil, retry.Classify(retry.DeadLetter, ErrDeleteMarker)
+	}
+	return s3.OpenVerified(ctx, sess, ev.Bucket, ev.Key, ev.VersionID, ev.Size, ev.ETag)
+}
+
+// Drain reads the rest of a body returned by Open, so that its verification
+// completes even when the handler stopped reading early.
+func Drain(body io.Reader) error {
+	_, err := io.Copy(io.Discard, body)
+	return s3.Classify(err)
+}
@@ -0,0 +1,40 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/vubon/aws-examples/sqs-with-s3/logging"
+	"github.com/vubon/aws-examples/sqs-with-s3/s3"
+)
+
+var log = logging.For("handler")
+
+// Print downloads the object, or archive entry, and prints its content.
+func Print(sess *session.Session) Handler {
+	return Func(func(ctx context.Context, ev *Event) (*Result, error) {
+		if ev.DeleteMarker() {
+			log.Info("delete marker created", logging.Bucket, ev.Bucket, logging.Key, ev.Key, logging.VersionID, ev.VersionID)
+			return nil, nil
+		}
+		if ev.Entry != "" {
+			fmt.Println("Entry:", ev.Entry)
+		}
+		obj, err := Open(ctx, sess, ev)
+		if err != nil {
+			return nil, err
+		}
+		defer obj.Body.Close()
+		_, err = io.Copy(os.Stdout, obj.Body)
+		fmt.Println()
+		// A closed stdout stops the copy before the object is verified, and a
+		// failed check should win over the write error.
+		if derr := Drain(obj.Body); derr != nil {
+			return nil, derr
+		}
+		return nil, s3.Classify(err)
+	})
+}
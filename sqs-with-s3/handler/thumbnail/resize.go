@@ -0,0 +1,71 @@
+package thumbnail
+
+import (
+	"image"
+	"image/draw"
+)
+
+// fit returns the size of w x h scaled down to fit in max x max, keeping the
+// aspect ratio. Images that already fit keep their size.
+func fit(w, h, max int) (int, int) {
+	if w <= max && h <= max {
+		return w, h
+	}
+	if w >= h {
+		return max, maxInt(1, h*max/w)
+	}
+	return maxInt(1, w*max/h), max
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// toNRGBA converts src once so resizing can work on the pixel slice.
+func toNRGBA(src image.Image) *image.NRGBA {
+	if img, ok := src.(*image.NRGBA); ok {
+		return img
+	}
+	b := src.Bounds()
+	img := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(img, img.Bounds(), src, b.Min, draw.Src)
+	return img
+}
+
+// resize downscales src to w x h with a box filter: every destination pixel
+// is the average of the source pixels it covers.
+func resize(src *image.NRGBA, w, h int) *image.NRGBA {
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0, y1 := y*sh/h, maxInt((y+1)*sh/h, y*sh/h+1)
+		for x := 0; x < w; x++ {
+			x0, x1 := x*sw/w, maxInt((x+1)*sw/w, x*sw/w+1)
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					pa := uint64(p[3])
+					// Weight colour by alpha so transparent pixels do not darken edges.
+					r += uint64(p[0]) * pa
+					g += uint64(p[1]) * pa
+					b += uint64(p[2]) * pa
+					a += pa
+					n++
+				}
+			}
+			d := dst.Pix[y*dst.Stride+x*4:]
+			if a > 0 {
+				d[0] = uint8(r / a)
+				d[1] = uint8(g / a)
+				d[2] = uint8(b / a)
+			}
+			d[3] = uint8(a / n)
+		}
+	}
+	return dst
+}
@@ -0,0 +1,223 @@
+package thumbnail
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/vubon/aws-examples/sqs-with-s3/handler"
+	"github.com/vubon/aws-examples/sqs-with-s3/retry"
+	"github.com/vubon/aws-examples/sqs-with-s3/s3"
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/webp"
+)
+
+// sniffBytes is how much of the object a ranged GET reads to detect the
+// content type and image dimensions before anything is decoded.
+const sniffBytes = 64 << 10
+
+// derivedFromKey marks thumbnails so they are never thumbnailed themselves.
+const derivedFromKey = "Derived-From"
+
+// Options configure a thumbnail handler.
+type Options struct {
+	// Sizes are the bounding boxes in pixels, e.g. [128, 512].
+	Sizes []int `json:"sizes"`
+	// TargetBucket receives the thumbnails, the source bucket by default.
+	TargetBucket string `json:"targetBucket"`
+	// TargetPrefix is prepended to the thumbnail keys, "thumbnails/" by default.
+	TargetPrefix string `json:"targetPrefix"`
+	// MaxPixels bounds the decoded image size, 40 megapixels by default.
+	MaxPixels int `json:"maxPixels"`
+	// Quality of JPEG thumbnails, 85 by default.
+	Quality int `json:"quality"`
+}
+
+type Thumbnailer struct {
+	sess *session.Session
+	opts Options
+}
+
+// New creates a thumbnail handler. It implements handler.Factory.
+func New(sess *session.Session, options json.RawMessage) (handler.Handler, error) {
+	t := &Thumbnailer{sess: sess}
+	if err := json.Unmarshal(options, &t.opts); err != nil {
+		return nil, err
+	}
+	if len(t.opts.Sizes) == 0 {
+		return nil, errors.New("thumbnail needs sizes")
+	}
+	for _, size := range t.opts.Sizes {
+		if size <= 0 {
+			return nil, fmt.Errorf("thumbnail size %d must be positive", size)
+		}
+	}
+	if t.opts.TargetPrefix == "" {
+		t.opts.TargetPrefix = "thumbnails/"
+	}
+	if t.opts.MaxPixels <= 0 {
+		t.opts.MaxPixels = 40_000_000
+	}
+	if t.opts.Quality <= 0 {
+		t.opts.Quality = 85
+	}
+	return t, nil
+}
+
+// Handle writes one thumbnail per configured size. Objects that are not
+// images, and thumbnails themselves, are skipped.
+func (t *Thumbnailer) Handle(ctx context.Context, ev *handler.Event) (*handler.Result, error) {
+	bucket := t.opts.TargetBucket
+	if bucket == "" {
+		bucket = ev.Bucket
+	}
+	if !strings.HasPrefix(ev.EventName, "ObjectCreated:") || ev.Size == 0 ||
+		ev.IsOutput(bucket, t.opts.TargetPrefix) {
+		return nil, nil
+	}
+
+	var cfg image.Config
+	var format string
+	var src image.Image
+	var err error
+	if ev.Entry != "" {
+		cfg, format, src, err = t.decodeEntry(ctx, ev)
+	} else {
+		cfg, format, src, err = t.decodeObject(ctx, ev)
+	}
+	if src == nil || err != nil {
+		return nil, err
+	}
+	pixels := toNRGBA(src)
+	// Let the decoded image be collected while the thumbnails are made.
+	src = nil
+
+	ext, outType := ".png", "image/png"
+	if format == "jpeg" || format == "webp" {
+		ext, outType = ".jpg", "image/jpeg"
+	}
+	base := strings.TrimSuffix(ev.Path(), path.Ext(ev.Path()))
+	var outputs []string
+	for _, size := range t.opts.Sizes {
+		w, h := fit(cfg.Width, cfg.Height, size)
+		thumb := resize(pixels, w, h)
+		var buf bytes.Buffer
+		if outType == "image/jpeg" {
+			err = jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: t.opts.Quality})
+		} else {
+			err = png.Encode(&buf, thumb)
+		}
+		if err != nil {
+			return nil, err
+		}
+		key := fmt.Sprintf("%s%s_%d%s", t.opts.TargetPrefix, base, size, ext)
+		location, err := s3.UploadWithMetadata(ctx, t.sess, bucket, key, outType,
+			map[string]string{derivedFromKey: ev.Bucket + "/" + ev.Path()}, &buf)
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, location)
+	}
+	return &handler.Result{Output: strings.Join(outputs, ",")}, nil
+}
+
+// decodeObject sniffs the object with a ranged GET and decodes it only when it
+// is a supported image within MaxPixels. A nil image means skip it.
+func (t *Thumbnailer) decodeObject(ctx context.Context, ev *handler.Event) (image.Config, string, image.Image, error) {
+	var cfg image.Config
+	head, info, err := s3.ReadRange(ctx, t.sess, ev.Bucket, ev.Key, ev.VersionID, 0, sniffBytes-1)
+	if err != nil {
+		return cfg, "", nil, err
+	}
+	for k := range info.Metadata {
+		if strings.EqualFold(k, derivedFromKey) {
+			return cfg, "", nil, nil
+		}
+	}
+	contentType := http.DetectContentType(head)
+	if !strings.HasPrefix(contentType, "image/") {
+		return cfg, "", nil, nil
+	}
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(head))
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		// Large metadata blocks can push the dimensions past the sniffed bytes,
+		// so read the header from the stream, stopping once they are known.
+		var obj *s3.Object
+		obj, err = handler.Open(ctx, t.sess, ev)
+		if err != nil {
+			return cfg, "", nil, err
+		}
+		cfg, format, err = image.DecodeConfig(obj.Body)
+		obj.Body.Close()
+	}
+	if err != nil {
+		// Unsupported formats such as SVG or TIFF are not thumbnailed.
+		return cfg, "", nil, nil
+	}
+	if err := t.checkPixels(cfg); err != nil {
+		return cfg, "", nil, err
+	}
+
+	obj, err := handler.Open(ctx, t.sess, ev)
+	if err != nil {
+		return cfg, "", nil, err
+	}
+	defer obj.Body.Close()
+	src, _, err := image.Decode(obj.Body)
+	if err != nil {
+		return cfg, "", nil, retry.Classify(retry.DeadLetter, fmt.Errorf("decode %s: %w", format, err))
+	}
+	// Read to the end so the download is verified before thumbnails are written.
+	if _, err := io.Copy(io.Discard, obj.Body); err != nil {
+		return cfg, "", nil, err
+	}
+	return cfg, format, src, nil
+}
+
+// decodeEntry is decodeObject for an archive entry, which can only be read
+// once: the sniffed bytes are buffered in front of the rest of the stream.
+// Entries whose dimensions are not within the first sniffBytes are skipped.
+func (t *Thumbnailer) decodeEntry(ctx context.Context, ev *handler.Event) (image.Config, string, image.Image, error) {
+	var cfg image.Config
+	obj, err := handler.Open(ctx, t.sess, ev)
+	if err != nil {
+		return cfg, "", nil, err
+	}
+	defer obj.Body.Close()
+	br := bufio.NewReaderSize(obj.Body, sniffBytes)
+	head, _ := br.Peek(sniffBytes)
+	if !strings.HasPrefix(http.DetectContentType(head), "image/") {
+		return cfg, "", nil, nil
+	}
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(head))
+	if err != nil {
+		return cfg, "", nil, nil
+	}
+	if err := t.checkPixels(cfg); err != nil {
+		return cfg, "", nil, err
+	}
+	src, _, err := image.Decode(br)
+	if err != nil {
+		return cfg, "", nil, retry.Classify(retry.DeadLetter, fmt.Errorf("decode %s: %w", format, err))
+	}
+	return cfg, format, src, nil
+}
+
+func (t *Thumbnailer) checkPixels(cfg image.Config) error {
+	if cfg.Width*cfg.Height > t.opts.MaxPixels {
+		return retry.Classify(retry.DeadLetter, fmt.Errorf("image %dx%d exceeds %d pixels", cfg.Width, cfg.Height, t.opts.MaxPixels))
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/vubon/aws-examples/sqs-with-s3/handler"
+	"github.com/vubon/aws-examples/sqs-with-s3/retry"
+	"github.com/vubon/aws-examples/sqs-with-s3/s3"
+)
+
+// Headers set on every delivery. The signature is the hex HMAC-SHA256 of
+// "<timestamp>.<body>" keyed with the endpoint secret.
+const (
+	SignatureHeader = "X-Webhook-Signature"
+	TimestampHeader = "X-Webhook-Timestamp"
+	DeliveryHeader  = "X-Webhook-Delivery"
+)
+
+// Options configure a webhook handler.
+type Options struct {
+	Endpoints []Endpoint `json:"endpoints"`
+	// PresignMinutes adds a presigned GET URL valid for this long to the
+	// payload. 0 leaves it out.
+	PresignMinutes int `json:"presignMinutes"`
+	// Attempts per endpoint before the message is requeued, 3 by default.
+	Attempts int `json:"attempts"`
+}
+
+// Endpoint is one receiver of the events.
+type Endpoint struct {
+	URL string `json:"url"`
+	// Secret signs the requests. SecretEnv names an environment variable
+	// holding it instead, to keep it out of the config file.
+	Secret    string `json:"secret"`
+	SecretEnv string `json:"secretEnv"`
+	// TimeoutSeconds bounds each request, 10 by default.
+	TimeoutSeconds int               `json:"timeoutSeconds"`
+	Headers        map[string]string `json:"headers"`
+}
+
+// Payload is the JSON body posted to the endpoints.
+type Payload struct {
+	Event      *handler.Event `json:"event"`
+	URL        string         `json:"url,omitempty"`
+	URLExpires *time.Time     `json:"urlExpires,omitempty"`
+}
+
+type Webhook struct {
+	sess    *session.Session
+	opts    Options
+	backoff retry.Backoff
+	client  *http.Client
+}
+
+// New creates a webhook handler. It implements handler.Factory.
+func New(sess *session.Session, options json.RawMessage) (handler.Handler, error) {
+	w := &Webhook{sess: sess, client: &http.Client{}}
+	if err := json.Unmarshal(options, &w.opts); err != nil {
+		return nil, err
+	}
+	if len(w.opts.Endpoints) == 0 {
+		return nil, errors.New("webhook needs endpoints")
+	}
+	for i := range w.opts.Endpoints {
+		ep := &w.opts.Endpoints[i]
+		if ep.URL == "" {
+			return nil, errors.New("webhook endpoint needs a url")
+		}
+		if ep.SecretEnv != "" {
+			ep.Secret = os.Getenv(ep.SecretEnv)
+		}
+		if ep.Secret == "" {
+			return nil, fmt.Errorf("webhook endpoint %s needs a secret", ep.URL)
+		}
+		if ep.TimeoutSeconds <= 0 {
+			ep.TimeoutSeconds = 10
+		}
+	}
+	if w.opts.Attempts <= 0 {
+		w.opts.Attempts = 3
+	}
+	w.backoff = retry.Backoff{Base: 500 * time.Millisecond, Max: 10 * time.Second, Attempts: w.opts.Attempts}
+	return w, nil
+}
+
+// Handle posts the event to every endpoint. Endpoints that fail after all
+// attempts requeue the message, so the others may see it again; receivers
+// should deduplicate on the delivery header.
+func (w *Webhook) Handle(ctx context.Context, ev *handler.Event) (*handler.Result, error) {
+	payload := Payload{Event: ev}
+	if w.opts.PresignMinutes > 0 && !strings.HasPrefix(ev.EventName, "ObjectRemoved:") {
+		expires := time.Duration(w.opts.PresignMinutes) * time.Minute
+		url, err := s3.PresignGet(w.sess, ev.Bucket, ev.Key, ev.VersionID, expires)
+		if err != nil {
+			return nil, retry.Classify(retry.Requeue, err)
+		}
+		at := time.Now().Add(expires).UTC()
+		payload.URL, payload.URLExpires = url, &at
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	details := map[string]string{}
+	var failed []error
+	for _, ep := range w.opts.Endpoints {
+		status := 0
+		err := retry.Do(ctx, w.backoff, func() (err error) {
+			status, err = w.post(ctx, ep, deliveryID(ev), body)
+			return err
+		})
+		details[ep.URL] = strconv.Itoa(status)
+		if err != nil {
+			failed = append(failed, fmt.Errorf("%s: %w", ep.URL, err))
+		}
+	}
+	res := &handler.Result{Details: details}
+	if len(failed) > 0 {
+		return res, retry.Classify(retry.Requeue, errors.Join(failed...))
+	}
+	return res, nil
+}
+
+// post sends one signed request and returns the response status. Network
+// errors and non-2xx responses are classified as retryable.
+func (w *Webhook) post(ctx context.Context, ep Endpoint, delivery string, body []byte) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(ep.TimeoutSeconds)*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ep.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, retry.Classify(retry.DeadLetter, err)
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	for k, v := range ep.Headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, "sha256="+Sign(ep.Secret, timestamp, body))
+	req.Header.Set(DeliveryHeader, delivery)
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return 0, retry.Classify(retry.Retry, err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, retry.Classify(retry.Retry, fmt.Errorf("unexpected status %s", resp.Status))
+	}
+	return resp.StatusCode, nil
+}
+
+// deliveryID is the same for every redelivery of an event.
+func deliveryID(ev *handler.Event) string {
+	if ev.Sequencer == "" {
+		return ev.MessageID
+	}
+	return ev.MessageID + "-" + ev.Sequencer
+}
+
+// Sign returns the hex HMAC-SHA256 of "<timestamp>.<body>". Receivers compute
+// the same value to verify a delivery.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
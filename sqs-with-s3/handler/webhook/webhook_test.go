@@ -0,0 +1,74 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/vubon/aws-examples/sqs-with-s3/handler"
+	"github.com/vubon/aws-examples/sqs-with-s3/retry"
+)
+
+func TestSign(t *testing.T) {
+	tests := []struct {
+		secret, timestamp, body, want string
+	}{
+		{"secret", "1700000000", "{}", "b8569b78799ff9e3cbff0fc2d63a33a2b57f3282abd07c37ae5e8e7d79a5f163"},
+		{"key", "0", "", "85841b4efc3cd7776c3c8f9b7cca9e281c550e5d19889d78e9e669c6337f000d"},
+		{"s3cr3t", "1704067200", `{"event":{"key":"a.csv"}}`, "e942356a096bccea5526b6b951de6603ce7e2a543b966d7bb55856fcf2ac77d7"},
+	}
+	for _, tt := range tests {
+		if got := Sign(tt.secret, tt.timestamp, []byte(tt.body)); got != tt.want {
+			t.Errorf("Sign(%q, %q, %q) = %s, want %s", tt.secret, tt.timestamp, tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestHandle(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		class  retry.Class
+	}{
+		{"delivered", http.StatusNoContent, -1},
+		{"rejected", http.StatusInternalServerError, retry.Requeue},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var delivery string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				if got, want := r.Header.Get(SignatureHeader), "sha256="+Sign("secret", r.Header.Get(TimestampHeader), body); got != want {
+					t.Errorf("signature %s, want %s", got, want)
+				}
+				delivery = r.Header.Get(DeliveryHeader)
+				w.WriteHeader(tt.status)
+			}))
+			defer srv.Close()
+			options, _ := json.Marshal(Options{Endpoints: []Endpoint{{URL: srv.URL, Secret: "secret"}}, Attempts: 1})
+			h, err := New(nil, options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ev := &handler.Event{MessageID: "m1", Sequencer: "0A", EventName: "ObjectCreated:Put", Bucket: "b", Key: "k"}
+			res, err := h.Handle(context.Background(), ev)
+			if tt.class >= 0 {
+				if err == nil || retry.ClassOf(err) != tt.class {
+					t.Fatalf("got %v, want class %v", err, tt.class)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if delivery != "m1-0A" {
+				t.Errorf("delivery %q, want m1-0A", delivery)
+			}
+			if got := res.Details[srv.URL]; got != strconv.Itoa(tt.status) {
+				t.Errorf("status detail %q, want %d", got, tt.status)
+			}
+		})
+	}
+}
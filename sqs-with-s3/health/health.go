@@ -0,0 +1,93 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Component is the last reported state of one part of the consumer.
+type Component struct {
+	Name    string    `json:"name"`
+	Healthy bool      `json:"healthy"`
+	Error   string    `json:"error,omitempty"`
+	Since   time.Time `json:"since"`
+}
+
+var (
+	mu         sync.Mutex
+	components = map[string]*Component{}
+	infos      = map[string]func() interface{}{}
+)
+
+// SetInfo adds a section to the /readyz response. It does not affect readiness.
+func SetInfo(name string, info func() interface{}) {
+	mu.Lock()
+	defer mu.Unlock()
+	infos[name] = info
+}
+
+// Set records the state of a component. A nil err marks it healthy.
+func Set(name string, err error) {
+	mu.Lock()
+	defer mu.Unlock()
+	healthy := err == nil
+	c, ok := components[name]
+	if !ok || c.Healthy != healthy {
+		c = &Component{Name: name, Healthy: healthy, Since: time.Now()}
+		components[name] = c
+	}
+	c.Error = ""
+	if err != nil {
+		c.Error = err.Error()
+	}
+}
+
+// Snapshot returns every reported component sorted by name, and whether all
+// of them are healthy.
+func Snapshot() ([]Component, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	list := make([]Component, 0, len(components))
+	ready := true
+	for _, c := range components {
+		list = append(list, *c)
+		ready = ready && c.Healthy
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list, ready
+}
+
+// LiveHandler answers /healthz. The process is live as long as it can serve.
+func LiveHandler(w http.ResponseWriter, _ *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+// ReadyHandler answers /readyz with the component states, returning 503 when
+// any component is unhealthy.
+func ReadyHandler(w http.ResponseWriter, _ *http.Request) {
+	list, ready := Snapshot()
+	mu.Lock()
+	sections := make(map[string]func() interface{}, len(infos))
+	for name, info := range infos {
+		sections[name] = info
+	}
+	mu.Unlock()
+	info := make(map[string]interface{}, len(sections))
+	for name, section := range sections {
+		info[name] = section()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(struct {
+		Ready      bool                   `json:"ready"`
+		Components []Component            `json:"components"`
+		Info       map[string]interface{} `json:"info,omitempty"`
+	}{ready, list, info})
+}
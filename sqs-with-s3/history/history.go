@@ -0,0 +1,184 @@
+package history
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/vubon/aws-examples/sqs-with-s3/logging"
+	"github.com/vubon/aws-examples/sqs-with-s3/metrics"
+	"github.com/vubon/aws-examples/sqs-with-s3/outcome"
+	bolt "go.etcd.io/bbolt"
+)
+
+var eventsBucket = []byte("events")
+
+var log = logging.For("history")
+
+// ErrNotFound is returned by Get for an unknown or expired ID.
+var ErrNotFound = errors.New("history: event not found")
+
+// Record is a stored outcome with its ID.
+type Record struct {
+	ID string `json:"id"`
+	outcome.Outcome
+}
+
+// Query filters records. Zero fields match everything.
+type Query struct {
+	Bucket    string
+	KeyPrefix string
+	Status    string
+	From      time.Time
+	To        time.Time
+	Limit     int
+}
+
+func (q *Query) match(r *Record) bool {
+	return (q.Bucket == "" || r.Bucket == q.Bucket) &&
+		strings.HasPrefix(r.Key, q.KeyPrefix) &&
+		(q.Status == "" || r.Status == q.Status)
+}
+
+// Store keeps outcomes in a BoltDB file. Keys are the record time in
+// nanoseconds followed by a sequence number, so records are ordered by time
+// and the ID is the hex encoded key.
+type Store struct {
+	db        *bolt.DB
+	retention time.Duration
+}
+
+// Open opens or creates the store at path and drops records older than
+// retention in the background.
+func Open(path string, retention time.Duration) (*Store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(eventsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	s := &Store{db: db, retention: retention}
+	go s.pruneLoop()
+	return s, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+func key(t time.Time, seq uint64) []byte {
+	k := make([]byte, 16)
+	binary.BigEndian.PutUint64(k, uint64(t.UnixNano()))
+	binary.BigEndian.PutUint64(k[8:], seq)
+	return k
+}
+
+// Publish stores an outcome. It implements outcome.Publisher.
+func (s *Store) Publish(o *outcome.Outcome) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(eventsBucket)
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		k := key(o.Time, seq)
+		data, err := json.Marshal(&Record{ID: hex.EncodeToString(k), Outcome: *o})
+		if err != nil {
+			return err
+		}
+		return b.Put(k, data)
+	})
+}
+
+// Get returns the record with the given ID.
+func (s *Store) Get(id string) (*Record, error) {
+	k, err := hex.DecodeString(id)
+	if err != nil || len(k) != 16 {
+		return nil, ErrNotFound
+	}
+	var r *Record
+	err = s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(eventsBucket).Get(k)
+		if data == nil {
+			return ErrNotFound
+		}
+		r = &Record{}
+		return json.Unmarshal(data, r)
+	})
+	return r, err
+}
+
+// Find returns the records matching q, newest first.
+func (s *Store) Find(q Query) ([]*Record, error) {
+	records := []*Record{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(eventsBucket).Cursor()
+		var k, v []byte
+		if q.To.IsZero() {
+			k, v = c.Last()
+		} else if k, v = c.Seek(key(q.To.Add(time.Nanosecond), 0)); k == nil {
+			k, v = c.Last()
+		} else {
+			k, v = c.Prev()
+		}
+		from := key(q.From, 0)
+		for ; k != nil && (q.From.IsZero() || bytes.Compare(k, from) >= 0); k, v = c.Prev() {
+			r := &Record{}
+			if err := json.Unmarshal(v, r); err != nil {
+				return fmt.Errorf("history record %x: %w", k, err)
+			}
+			if !q.match(r) {
+				continue
+			}
+			records = append(records, r)
+			if q.Limit > 0 && len(records) >= q.Limit {
+				break
+			}
+		}
+		return nil
+	})
+	return records, err
+}
+
+// Prune deletes records older than the retention window.
+func (s *Store) Prune() (int, error) {
+	cutoff := key(time.Now().Add(-s.retention), 0)
+	var expired [][]byte
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(eventsBucket)
+		c := b.Cursor()
+		// Deleting through the cursor while iterating skips keys, so collect first.
+		for k, _ := c.First(); k != nil && bytes.Compare(k, cutoff) < 0; k, _ = c.Next() {
+			expired = append(expired, k)
+		}
+		for _, k := range expired {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return len(expired), err
+}
+
+func (s *Store) pruneLoop() {
+	for range time.Tick(10 * time.Minute) {
+		deleted, err := s.Prune()
+		if err != nil {
+			log.Error("prune failed", logging.Err(err))
+			continue
+		}
+		metrics.Add("history_pruned_total", nil, float64(deleted))
+	}
+}
@@ -0,0 +1,66 @@
+package history
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/vubon/aws-examples/sqs-with-s3/outcome"
+)
+
+func TestFind(t *testing.T) {
+	s, err := Open(filepath.Join(t.TempDir(), "history.db"), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(minutes int) time.Time { return base.Add(time.Duration(minutes) * time.Minute) }
+	for i, o := range []outcome.Outcome{
+		{Bucket: "a", Key: "in/1.csv", Status: outcome.StatusSuccess},
+		{Bucket: "a", Key: "in/2.csv", Status: "dead-letter"},
+		{Bucket: "b", Key: "in/3.csv", Status: outcome.StatusSuccess},
+		{Bucket: "a", Key: "out/4.csv", Status: outcome.StatusSuccess},
+	} {
+		o.Time = at(i)
+		if err := s.Publish(&o); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		q    Query
+		keys []string
+	}{
+		{"all, newest first", Query{}, []string{"out/4.csv", "in/3.csv", "in/2.csv", "in/1.csv"}},
+		{"bucket", Query{Bucket: "a"}, []string{"out/4.csv", "in/2.csv", "in/1.csv"}},
+		{"key prefix", Query{KeyPrefix: "in/"}, []string{"in/3.csv", "in/2.csv", "in/1.csv"}},
+		{"status", Query{Status: "dead-letter"}, []string{"in/2.csv"}},
+		{"limit", Query{Bucket: "a", Limit: 2}, []string{"out/4.csv", "in/2.csv"}},
+		{"from and to inclusive", Query{From: at(1), To: at(2)}, []string{"in/3.csv", "in/2.csv"}},
+		{"to between records", Query{To: at(1).Add(time.Second)}, []string{"in/2.csv", "in/1.csv"}},
+		{"to after the last", Query{To: at(10)}, []string{"out/4.csv", "in/3.csv", "in/2.csv", "in/1.csv"}},
+		{"to before the first", Query{To: at(-1)}, nil},
+		{"from after the last", Query{From: at(10)}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, err := s.Find(tt.q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var keys []string
+			for _, r := range records {
+				keys = append(keys, r.Key)
+				if got, err := s.Get(r.ID); err != nil || got.Key != r.Key {
+					t.Errorf("Get(%s) = %v, %v", r.ID, got, err)
+				}
+			}
+			if !reflect.DeepEqual(keys, tt.keys) {
+				t.Errorf("found %v, want %v", keys, tt.keys)
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/vubon/aws-examples/sqs-with-s3/config"
+)
+
+// Field names shared by every component, so that one query finds all lines
+// about a message or object.
+const (
+	Component = "component"
+	Queue     = "queue"
+	MessageID = "messageId"
+	Bucket    = "bucket"
+	Key       = "key"
+	VersionID = "versionId"
+	Entry     = "entry"
+	EventName = "eventName"
+	Handler   = "handler"
+	Error     = "error"
+)
+
+var (
+	mu     sync.RWMutex
+	base   slog.Handler = newHandler(os.Stdout, "text")
+	level               = slog.LevelInfo
+	levels              = map[string]slog.Level{}
+	// generation counts the calls to Setup, so handlers know when the
+	// handler they built from base is stale.
+	generation uint64
+)
+
+// Setup switches every logger, including those created before it was called,
+// to the format and levels in cfg. A nil cfg is text output at info.
+func Setup(cfg *config.Logging) error {
+	if cfg == nil {
+		cfg = &config.Logging{}
+	}
+	def, err := parseLevel(cfg.Level)
+	if err != nil {
+		return err
+	}
+	components := make(map[string]slog.Level, len(cfg.Components))
+	for name, l := range cfg.Components {
+		if components[name], err = parseLevel(l); err != nil {
+			return fmt.Errorf("component %s: %w", name, err)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	base = newHandler(os.Stdout, cfg.Format)
+	generation++
+	level = def
+	levels = components
+	slog.SetDefault(slog.New(&handler{component: "main", wrap: identity}))
+	return nil
+}
+
+// For returns the logger of a component. Its level is the component's own
+// from the config, or the default level.
+func For(component string) *slog.Logger {
+	return slog.New(&handler{component: component, wrap: identity})
+}
+
+// Err is the attribute for an error.
+func Err(err error) slog.Attr {
+	return slog.Any(Error, err)
+}
+
+func newHandler(w io.Writer, format string) slog.Handler {
+	// Levels are checked per component before records reach the handler.
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug}
+	if format == "json" {
+		return slog.NewJSONHandler(w, opts)
+	}
+	return slog.NewTextHandler(w, opts)
+}
+
+func parseLevel(s string) (slog.Level, error) {
+	var l slog.Level
+	if s == "" {
+		return slog.LevelInfo, nil
+	}
+	err := l.UnmarshalText([]byte(strings.ToUpper(s)))
+	return l, err
+}
+
+func identity(h slog.Handler) slog.Handler { return h }
+
+// handler resolves the configured handler and level on every record, so that
+// package-level loggers follow Setup. The handler built from base is cached
+// until the next Setup.
+type handler struct {
+	component string
+	// wrap replays WithAttrs and WithGroup calls on the configured handler.
+	wrap  func(slog.Handler) slog.Handler
+	cache atomic.Pointer[built]
+}
+
+// built is a handler made from base by a handler, and the Setup generation
+// of that base.
+type built struct {
+	generation uint64
+	handler    slog.Handler
+}
+
+func (h *handler) Enabled(_ context.Context, l slog.Level) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	min, ok := levels[h.component]
+	if !ok {
+		min = level
+	}
+	return l >= min
+}
+
+func (h *handler) Handle(ctx context.Context, r slog.Record) error {
+	mu.RLock()
+	b, gen := base, generation
+	mu.RUnlock()
+	c := h.cache.Load()
+	if c == nil || c.generation != gen {
+		c = &built{generation: gen, handler: h.wrap(b.WithAttrs([]slog.Attr{slog.String(Component, h.component)}))}
+		h.cache.Store(c)
+	}
+	return c.handler.Handle(ctx, r)
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	wrap := h.wrap
+	return &handler{component: h.component, wrap: func(b slog.Handler) slog.Handler {
+		return wrap(b).WithAttrs(attrs)
+	}}
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	wrap := h.wrap
+	return &handler{component: h.component, wrap: func(b slog.Handler) slog.Handler {
+		return wrap(b).WithGroup(name)
+	}}
+}
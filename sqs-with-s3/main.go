@@ -1,25 +1,114 @@
 package main
 
 import (
-	"fmt"
+	"context"
+	"flag"
 	"net/http"
 	"os"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/vubon/aws-examples/sqs-with-s3/admin"
+	"github.com/vubon/aws-examples/sqs-with-s3/app"
+	"github.com/vubon/aws-examples/sqs-with-s3/config"
+	"github.com/vubon/aws-examples/sqs-with-s3/health"
+	"github.com/vubon/aws-examples/sqs-with-s3/history"
+	"github.com/vubon/aws-examples/sqs-with-s3/logging"
+	"github.com/vubon/aws-examples/sqs-with-s3/metrics"
+	"github.com/vubon/aws-examples/sqs-with-s3/outcome"
+	"github.com/vubon/aws-examples/sqs-with-s3/restore"
 	"github.com/vubon/aws-examples/sqs-with-s3/sqs"
+	"github.com/vubon/aws-examples/sqs-with-s3/stream"
 )
 
+var configPath = flag.String("config", "config.json", "Consumer config file")
+
+var log = logging.For("main")
+
 func main() {
+	flag.Parse()
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Error("config load failed", logging.Err(err))
+		os.Exit(1)
+	}
+	if err := logging.Setup(cfg.Logging); err != nil {
+		log.Error("logging setup failed", logging.Err(err))
+		os.Exit(1)
+	}
+
+	// Create a session that gets credential values from ~/.aws/credentials
+	// and the default region from ~/.aws/config
+	sess := session.Must(session.NewSessionWithOptions(session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+	}))
+	shutdown, err := app.Init(context.Background(), sess, cfg)
+	if err != nil {
+		log.Error("setup failed", logging.Err(err))
+		os.Exit(1)
+	}
+	defer shutdown(context.Background())
+
+	publishers, err := outcome.FromConfig(sess, cfg.Results)
+	if err != nil {
+		log.Error("outcome publisher setup failed", logging.Err(err))
+		os.Exit(1)
+	}
+
+	hub := stream.NewHub()
+	publishers = append(publishers, hub)
+
+	var store *history.Store
+	if cfg.History != nil {
+		store, err = history.Open(cfg.History.Path, time.Duration(cfg.History.RetentionHours)*time.Hour)
+		if err != nil {
+			log.Error("history store open failed", logging.Err(err))
+			os.Exit(1)
+		}
+		defer store.Close()
+		publishers = append(publishers, store)
+	}
+
+	var restores *restore.Store
+	if cfg.Restore != nil {
+		restores, err = restore.Open(sess, cfg.Restore)
+		if err != nil {
+			log.Error("restore store open failed", logging.Err(err))
+			os.Exit(1)
+		}
+		defer restores.Close()
+	}
+
+	shared := &sqs.Shared{
+		Publisher: publishers,
+		Stream:    hub,
+		Restores:  restores,
+	}
+	group, err := sqs.SQS(sess, cfg, shared)
+	if err != nil {
+		log.Error("consumer setup failed", logging.Err(err))
+		os.Exit(1)
+	}
+
 	mux := http.NewServeMux()
-	go func() {
-		defer func() {
-			if err := recover(); err != nil {
-				fmt.Println("SQS recover from panic attack ", err)
-			}
-		}()
-		sqs.SQS()
-	}()
-	err := http.ListenAndServe(":8080", mux)
+	mux.HandleFunc("/healthz", health.LiveHandler)
+	mux.HandleFunc("/readyz", health.ReadyHandler)
+	mux.HandleFunc("/metrics", metrics.Handler)
+	mux.Handle("/events/stream", hub)
+	if store != nil {
+		mux.HandleFunc("/events", store.ListHandler)
+		mux.HandleFunc("/events/", store.GetHandler)
+	}
+	if cfg.Admin != nil {
+		adminMux := admin.New(cfg.Admin.Token, group)
+		if restores != nil {
+			adminMux.HandleFunc("/admin/restores", restores.ListHandler)
+		}
+		mux.Handle("/admin/", adminMux)
+	}
+	err = http.ListenAndServe(cfg.Listen, mux)
 	if err != nil {
+		log.Error("http server failed", logging.Err(err))
 		os.Exit(1)
 	}
 
@@ -0,0 +1,114 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Labels are the label pairs of one series.
+type Labels map[string]string
+
+type kind string
+
+const (
+	counter kind = "counter"
+	gauge   kind = "gauge"
+)
+
+type series struct {
+	name   string
+	labels string
+	value  float64
+}
+
+var (
+	mu     sync.Mutex
+	kinds  = map[string]kind{}
+	values = map[string]*series{}
+)
+
+// Inc adds one to a counter.
+func Inc(name string, labels Labels) {
+	Add(name, labels, 1)
+}
+
+// Add adds delta to a counter.
+func Add(name string, labels Labels, delta float64) {
+	mu.Lock()
+	defer mu.Unlock()
+	lookup(name, labels, counter).value += delta
+}
+
+// Set sets a gauge to value.
+func Set(name string, labels Labels, value float64) {
+	mu.Lock()
+	defer mu.Unlock()
+	lookup(name, labels, gauge).value = value
+}
+
+// Gauge adds delta to a gauge, e.g. to track in-flight work.
+func Gauge(name string, labels Labels, delta float64) {
+	mu.Lock()
+	defer mu.Unlock()
+	lookup(name, labels, gauge).value += delta
+}
+
+func lookup(name string, labels Labels, k kind) *series {
+	kinds[name] = k
+	l := formatLabels(labels)
+	s, ok := values[name+l]
+	if !ok {
+		s = &series{name: name, labels: l}
+		values[name+l] = s
+	}
+	return s
+}
+
+func formatLabels(labels Labels) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = fmt.Sprintf("%s=%q", k, labels[k])
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Handler serves every series in the Prometheus text format.
+func Handler(w http.ResponseWriter, _ *http.Request) {
+	mu.Lock()
+	list := make([]series, 0, len(values))
+	for _, s := range values {
+		list = append(list, *s)
+	}
+	types := make(map[string]kind, len(kinds))
+	for name, k := range kinds {
+		types[name] = k
+	}
+	mu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].name != list[j].name {
+			return list[i].name < list[j].name
+		}
+		return list[i].labels < list[j].labels
+	})
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	last := ""
+	for _, s := range list {
+		if s.name != last {
+			fmt.Fprintf(w, "# TYPE %s %s\n", s.name, types[s.name])
+			last = s.name
+		}
+		fmt.Fprintf(w, "%s%s %g\n", s.name, s.labels, s.value)
+	}
+}
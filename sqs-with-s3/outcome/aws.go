@@ -0,0 +1,96 @@
+package outcome
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/vubon/aws-examples/sqs-with-s3/config"
+)
+
+// QueuePublisher sends outcomes to an SQS queue.
+type QueuePublisher struct {
+	svc      *sqs.SQS
+	queueURL *string
+	fifo     bool
+}
+
+// NewQueuePublisher resolves the queue URL of name.
+func NewQueuePublisher(sess *session.Session, name string) (*QueuePublisher, error) {
+	svc := sqs.New(sess)
+	urlResult, err := svc.GetQueueUrl(&sqs.GetQueueUrlInput{QueueName: aws.String(name)})
+	if err != nil {
+		return nil, err
+	}
+	return &QueuePublisher{svc: svc, queueURL: urlResult.QueueUrl, fifo: isFIFO(name)}, nil
+}
+
+func (p *QueuePublisher) Publish(o *Outcome) error {
+	body, err := json.Marshal(o)
+	if err != nil {
+		return err
+	}
+	input := &sqs.SendMessageInput{
+		QueueUrl:    p.queueURL,
+		MessageBody: aws.String(string(body)),
+	}
+	if p.fifo {
+		input.MessageGroupId = aws.String(o.groupID())
+		input.MessageDeduplicationId = aws.String(o.deduplicationID())
+	}
+	_, err = p.svc.SendMessage(input)
+	return err
+}
+
+// TopicPublisher publishes outcomes to an SNS topic.
+type TopicPublisher struct {
+	svc      *sns.SNS
+	topicArn string
+	fifo     bool
+}
+
+func NewTopicPublisher(sess *session.Session, topicArn string) *TopicPublisher {
+	return &TopicPublisher{svc: sns.New(sess), topicArn: topicArn, fifo: isFIFO(topicArn)}
+}
+
+func (p *TopicPublisher) Publish(o *Outcome) error {
+	body, err := json.Marshal(o)
+	if err != nil {
+		return err
+	}
+	input := &sns.PublishInput{
+		TopicArn: aws.String(p.topicArn),
+		Message:  aws.String(string(body)),
+		MessageAttributes: map[string]*sns.MessageAttributeValue{
+			"status": {DataType: aws.String("String"), StringValue: aws.String(o.Status)},
+		},
+	}
+	if p.fifo {
+		input.MessageGroupId = aws.String(o.groupID())
+		input.MessageDeduplicationId = aws.String(o.deduplicationID())
+	}
+	_, err = p.svc.Publish(input)
+	return err
+}
+
+// FromConfig creates the publishers named in cfg. A nil cfg publishes nowhere.
+func FromConfig(sess *session.Session, cfg *config.Results) (Multi, error) {
+	var publishers Multi
+	if cfg == nil {
+		return publishers, nil
+	}
+	if cfg.Queue != "" {
+		p, err := NewQueuePublisher(sess, cfg.Queue)
+		if err != nil {
+			return nil, fmt.Errorf("results queue %s: %w", cfg.Queue, err)
+		}
+		publishers = append(publishers, p)
+	}
+	if cfg.TopicArn != "" {
+		publishers = append(publishers, NewTopicPublisher(sess, cfg.TopicArn))
+	}
+	return publishers, nil
+}
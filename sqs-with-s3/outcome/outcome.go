@@ -0,0 +1,74 @@
+package outcome
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Outcome is the result of one handler run against one event record.
+type Outcome struct {
+	MessageID  string    `json:"messageId"`
+	Queue      string    `json:"queue"`
+	EventName  string    `json:"eventName"`
+	EventTime  time.Time `json:"eventTime"`
+	Bucket     string    `json:"bucket"`
+	Key        string    `json:"key"`
+	Entry      string    `json:"entry,omitempty"`
+	VersionID  string    `json:"versionId,omitempty"`
+	Handler    string    `json:"handler"`
+	Status     string    `json:"status"`
+	DurationMs int64     `json:"durationMs"`
+	Error      string    `json:"error,omitempty"`
+	Output     string    `json:"output,omitempty"`
+	// Details are handler specific, e.g. the captured output of a command.
+	Details map[string]string `json:"details,omitempty"`
+	Time    time.Time         `json:"time"`
+}
+
+// StatusSuccess is the status of a handler run without error. Failed runs
+// carry the retry class of their error instead.
+const StatusSuccess = "success"
+
+// Publisher delivers outcomes somewhere outside the consumer.
+type Publisher interface {
+	Publish(o *Outcome) error
+}
+
+// groupID keeps outcomes of one object in order on FIFO destinations.
+func (o *Outcome) groupID() string {
+	return hash(o.Bucket, o.Key)
+}
+
+// deduplicationID is stable for redeliveries of the same result, and
+// differs between the entries of an expanded archive.
+func (o *Outcome) deduplicationID() string {
+	return hash(o.MessageID, o.Handler, o.Bucket, o.Key, o.Entry, o.VersionID, o.Status)
+}
+
+func hash(parts ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+func isFIFO(name string) bool {
+	return strings.HasSuffix(name, ".fifo")
+}
+
+// Multi publishes to every publisher and returns the errors joined.
+type Multi []Publisher
+
+func (m Multi) Publish(o *Outcome) error {
+	var errs []string
+	for _, p := range m {
+		if err := p.Publish(o); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("publish outcome: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package outcome
+
+import "testing"
+
+func TestDeduplicationID(t *testing.T) {
+	base := Outcome{MessageID: "m1", Handler: "h", Bucket: "b", Key: "a.zip", Status: StatusSuccess}
+	tests := []struct {
+		name   string
+		change func(o *Outcome)
+		same   bool
+	}{
+		{"redelivery", func(o *Outcome) { o.DurationMs = 42 }, true},
+		{"other entry", func(o *Outcome) { o.Entry = "b.csv" }, false},
+		{"other version", func(o *Outcome) { o.VersionID = "v2" }, false},
+		{"other status", func(o *Outcome) { o.Status = "requeue" }, false},
+		{"other handler", func(o *Outcome) { o.Handler = "g" }, false},
+	}
+	first := base
+	first.Entry = "a.csv"
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := first
+			tt.change(&o)
+			if same := o.deduplicationID() == first.deduplicationID(); same != tt.same {
+				t.Errorf("same deduplication ID = %v, want %v", same, tt.same)
+			}
+		})
+	}
+}
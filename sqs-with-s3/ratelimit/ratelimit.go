@@ -0,0 +1,138 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/vubon/aws-examples/sqs-with-s3/config"
+	"github.com/vubon/aws-examples/sqs-with-s3/metrics"
+)
+
+// Wildcard is the config key whose rate applies to every bucket or handler
+// without its own entry. Each bucket or handler still gets its own tokens.
+const Wildcard = "*"
+
+// bucket is a token bucket refilled at rate tokens per second up to burst.
+type bucket struct {
+	scope  string
+	name   string
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func (b *bucket) refill(now time.Time) {
+	// A bucket created after now, by the call reading it, is already full.
+	if !now.After(b.last) {
+		return
+	}
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// wait returns how long until n tokens are available.
+func (b *bucket) wait(n float64) time.Duration {
+	if b.tokens >= n {
+		return 0
+	}
+	return time.Duration((n - b.tokens) / b.rate * float64(time.Second))
+}
+
+// Use is one handler run against an object in a bucket.
+type Use struct {
+	Bucket  string
+	Handler string
+}
+
+// Limiter applies global, per-bucket and per-handler token buckets.
+type Limiter struct {
+	cfg     config.RateLimits
+	mu      sync.Mutex
+	global  *bucket
+	buckets map[string]*bucket
+}
+
+// New creates a limiter from config. A nil limiter allows everything.
+func New(cfg *config.RateLimits) *Limiter {
+	if cfg == nil {
+		return nil
+	}
+	l := &Limiter{cfg: *cfg, buckets: map[string]*bucket{}}
+	if cfg.Global != nil {
+		l.global = newBucket("global", "", *cfg.Global)
+	}
+	return l
+}
+
+func newBucket(scope, name string, rate config.Rate) *bucket {
+	burst := float64(rate.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+	return &bucket{scope: scope, name: name, rate: rate.PerSecond, burst: burst, tokens: burst, last: time.Now()}
+}
+
+func (l *Limiter) lookup(scope, name string, rates map[string]config.Rate) *bucket {
+	rate, ok := rates[name]
+	if !ok {
+		rate, ok = rates[Wildcard]
+	}
+	if !ok {
+		return nil
+	}
+	key := scope + "/" + name
+	b, ok := l.buckets[key]
+	if !ok {
+		b = newBucket(scope, name, rate)
+		l.buckets[key] = b
+	}
+	return b
+}
+
+// Allow takes one token per use from every applicable bucket, or none at all.
+// When a bucket is short it returns false and how long until the uses fit.
+func (l *Limiter) Allow(uses []Use) (bool, time.Duration) {
+	if l == nil || len(uses) == 0 {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	needed := map[*bucket]float64{}
+	for _, u := range uses {
+		if l.global != nil {
+			needed[l.global]++
+		}
+		if b := l.lookup("bucket", u.Bucket, l.cfg.Buckets); b != nil {
+			needed[b]++
+		}
+		if b := l.lookup("handler", u.Handler, l.cfg.Handlers); b != nil {
+			needed[b]++
+		}
+	}
+
+	var wait time.Duration
+	for b, n := range needed {
+		b.refill(now)
+		if n > b.burst {
+			// More than the bucket can ever hold; let it through when full.
+			n = b.burst
+			needed[b] = n
+		}
+		if w := b.wait(n); w > wait {
+			wait = w
+		}
+	}
+	for b, n := range needed {
+		if wait == 0 {
+			b.tokens -= n
+		} else if b.wait(n) > 0 {
+			metrics.Inc("ratelimit_throttled_total", metrics.Labels{"scope": b.scope, "name": b.name})
+		}
+		metrics.Set("ratelimit_tokens", metrics.Labels{"scope": b.scope, "name": b.name}, b.tokens)
+	}
+	return wait == 0, wait
+}
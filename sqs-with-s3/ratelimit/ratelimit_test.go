@@ -0,0 +1,65 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/vubon/aws-examples/sqs-with-s3/config"
+)
+
+func TestAllow(t *testing.T) {
+	slow := config.Rate{PerSecond: 1, Burst: 2}
+	one := config.Rate{PerSecond: 1, Burst: 1}
+	a := []Use{{Bucket: "a", Handler: "print"}}
+	b := []Use{{Bucket: "b", Handler: "print"}}
+	tests := []struct {
+		name  string
+		cfg   *config.RateLimits
+		uses  [][]Use
+		allow []bool
+	}{
+		{"unlimited", nil, [][]Use{a, a, a}, []bool{true, true, true}},
+		{"global", &config.RateLimits{Global: &slow}, [][]Use{a, b, a}, []bool{true, true, false}},
+		{"bucket", &config.RateLimits{Buckets: map[string]config.Rate{"a": one}}, [][]Use{a, a, b, b}, []bool{true, false, true, true}},
+		{"wildcard buckets", &config.RateLimits{Buckets: map[string]config.Rate{Wildcard: one}}, [][]Use{a, b, a}, []bool{true, true, false}},
+		{"handler", &config.RateLimits{Handlers: map[string]config.Rate{"print": one}}, [][]Use{a, b}, []bool{true, false}},
+		// Two uses of a bucket of one token pass once it is full.
+		{"batch over burst", &config.RateLimits{Handlers: map[string]config.Rate{"print": one}}, [][]Use{append(a, b...), a}, []bool{true, false}},
+		// A denied use takes no tokens from the buckets that had them.
+		{"all or nothing", &config.RateLimits{Global: &slow, Buckets: map[string]config.Rate{"a": one}}, [][]Use{a, a, b, a}, []bool{true, false, true, false}},
+		{"empty", &config.RateLimits{Global: &one}, [][]Use{nil, nil, a}, []bool{true, true, true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := New(tt.cfg)
+			for i, uses := range tt.uses {
+				ok, wait := l.Allow(uses)
+				if ok != tt.allow[i] {
+					t.Fatalf("use %d: allowed %v, want %v", i, ok, tt.allow[i])
+				}
+				if ok && wait != 0 || !ok && (wait <= 0 || wait > time.Second) {
+					t.Errorf("use %d: wait %v", i, wait)
+				}
+			}
+		})
+	}
+}
+
+func TestBucketWait(t *testing.T) {
+	tests := []struct {
+		tokens, rate, n float64
+		want            time.Duration
+	}{
+		{2, 1, 1, 0},
+		{1, 1, 1, 0},
+		{0, 1, 1, time.Second},
+		{0.5, 2, 1, 250 * time.Millisecond},
+		{0, 10, 3, 300 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		b := &bucket{tokens: tt.tokens, rate: tt.rate}
+		if got := b.wait(tt.n); got != tt.want {
+			t.Errorf("wait(%v) with %v tokens at %v/s = %v, want %v", tt.n, tt.tokens, tt.rate, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package restore
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// ListHandler answers GET requests with the restore entries, filtered by the
+// optional bucket and status ("restore-requested" or "completed") parameters.
+func (s *Store) ListHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	all, err := s.List()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	bucket, status := r.URL.Query().Get("bucket"), r.URL.Query().Get("status")
+	list := make([]*Entry, 0, len(all))
+	for _, e := range all {
+		if (bucket == "" || e.Event.Bucket == bucket) && (status == "" || e.Status == status) {
+			list = append(list, e)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(list)
+}
@@ -0,0 +1,276 @@
+package restore
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/vubon/aws-examples/sqs-with-s3/config"
+	"github.com/vubon/aws-examples/sqs-with-s3/handler"
+	"github.com/vubon/aws-examples/sqs-with-s3/logging"
+	"github.com/vubon/aws-examples/sqs-with-s3/metrics"
+	"github.com/vubon/aws-examples/sqs-with-s3/s3"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Statuses of a restore entry. StatusRequested is also the outcome status of
+// the handler run that found the object archived.
+const (
+	StatusRequested = "restore-requested"
+	StatusCompleted = "completed"
+)
+
+var (
+	restoresBucket = []byte("restores")
+	// waitingBucket indexes requested entries by queue and object, so a
+	// completion event does not scan every entry.
+	waitingBucket = []byte("waiting")
+)
+
+var log = logging.For("restore")
+
+// Entry is a handler run waiting for an archived object to be restored.
+type Entry struct {
+	ID      string `json:"id"`
+	Queue   string `json:"queue"`
+	Handler string `json:"handler"`
+	// ExpandArchives is set when the handler's route expands archives.
+	ExpandArchives bool `json:"expandArchives,omitempty"`
+	// Event is the event the handler was given, and is given again once
+	// the object is restored.
+	Event       handler.Event `json:"event"`
+	Tier        string        `json:"tier"`
+	Days        int           `json:"days"`
+	Status      string        `json:"status"`
+	RequestedAt time.Time     `json:"requestedAt"`
+	CompletedAt *time.Time    `json:"completedAt,omitempty"`
+	// Error is the last error of a resumed handler run.
+	Error string `json:"error,omitempty"`
+}
+
+// Store requests restores and keeps their entries in a BoltDB file, keyed by
+// queue, handler and object version so redeliveries do not add duplicates.
+type Store struct {
+	db   *bolt.DB
+	sess *session.Session
+	cfg  config.Restore
+}
+
+// Open opens or creates the store configured by cfg and drops completed
+// entries older than its retention in the background.
+func Open(sess *session.Session, cfg *config.Restore) (*Store, error) {
+	db, err := bolt.Open(cfg.Path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		entries, err := tx.CreateBucketIfNotExists(restoresBucket)
+		if err != nil {
+			return err
+		}
+		if tx.Bucket(waitingBucket) != nil {
+			return nil
+		}
+		// Index the requested entries of a store written before the index.
+		waiting, err := tx.CreateBucket(waitingBucket)
+		if err != nil {
+			return err
+		}
+		return entries.ForEach(func(_, data []byte) error {
+			e := &Entry{}
+			if err := json.Unmarshal(data, e); err != nil {
+				return err
+			}
+			if e.Status != StatusRequested {
+				return nil
+			}
+			return waiting.Put(waitingKey(e), nil)
+		})
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	s := &Store{db: db, sess: sess, cfg: *cfg}
+	go s.pruneLoop()
+	return s, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+func entryID(queue, handlerName string, ev *handler.Event) string {
+	sum := sha256.New()
+	for _, part := range []string{queue, handlerName, ev.Bucket, ev.Key, ev.VersionID} {
+		sum.Write([]byte(part))
+		sum.Write([]byte{0})
+	}
+	return hex.EncodeToString(sum.Sum(nil)[:16])
+}
+
+func objectPrefix(queue, bucket, key string) []byte {
+	return []byte(queue + "\x00" + bucket + "\x00" + key + "\x00")
+}
+
+func waitingKey(e *Entry) []byte {
+	return append(objectPrefix(e.Queue, e.Event.Bucket, e.Event.Key), e.ID...)
+}
+
+// Request restores the archived object of ev with the configured tier and
+// days, and records that the route's handler should run again once it is
+// readable.
+func (s *Store) Request(ctx context.Context, queue string, route config.Route, ev *handler.Event) error {
+	if err := s3.Restore(ctx, s.sess, ev.Bucket, ev.Key, ev.VersionID, s.cfg.Tier, s.cfg.Days); err != nil {
+		return err
+	}
+	e := &Entry{
+		ID:             entryID(queue, route.Handler, ev),
+		Queue:          queue,
+		Handler:        route.Handler,
+		ExpandArchives: route.ExpandArchives,
+		Event:          *ev,
+		Tier:           s.cfg.Tier,
+		Days:           s.cfg.Days,
+		Status:         StatusRequested,
+		RequestedAt:    time.Now(),
+	}
+	metrics.Inc("restore_requests_total", metrics.Labels{"queue": queue, "tier": s.cfg.Tier})
+	return s.Update(e)
+}
+
+// Waiting returns the requested entries of a queue for an object version.
+// An empty versionID matches every version.
+func (s *Store) Waiting(queue, bucket, key, versionID string) ([]*Entry, error) {
+	var waiting []*Entry
+	prefix := objectPrefix(queue, bucket, key)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		entries := tx.Bucket(restoresBucket)
+		c := tx.Bucket(waitingBucket).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			data := entries.Get(k[len(prefix):])
+			if data == nil {
+				continue
+			}
+			e := &Entry{}
+			if err := json.Unmarshal(data, e); err != nil {
+				return err
+			}
+			if e.Status == StatusRequested && (versionID == "" || e.Event.VersionID == "" || e.Event.VersionID == versionID) {
+				waiting = append(waiting, e)
+			}
+		}
+		return nil
+	})
+	return waiting, err
+}
+
+// Complete marks the entry handled, unless the resumed handler found the
+// object archived again and requested a new restore in the meantime.
+func (s *Store) Complete(e *Entry) error {
+	current, err := s.get(e.ID)
+	if err != nil {
+		return err
+	}
+	if current != nil && current.RequestedAt.After(e.RequestedAt) {
+		return nil
+	}
+	now := time.Now()
+	e.Status = StatusCompleted
+	e.CompletedAt = &now
+	e.Error = ""
+	return s.Update(e)
+}
+
+// Update stores the entry, and indexes it while it is waiting for a restore.
+func (s *Store) Update(e *Entry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(restoresBucket).Put([]byte(e.ID), data); err != nil {
+			return err
+		}
+		if e.Status == StatusRequested {
+			return tx.Bucket(waitingBucket).Put(waitingKey(e), nil)
+		}
+		return tx.Bucket(waitingBucket).Delete(waitingKey(e))
+	})
+}
+
+// Prune deletes entries completed longer ago than the retention window.
+func (s *Store) Prune() (int, error) {
+	cutoff := time.Now().Add(-time.Duration(s.cfg.RetentionHours) * time.Hour)
+	var expired [][]byte
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(restoresBucket)
+		err := b.ForEach(func(id, data []byte) error {
+			e := &Entry{}
+			if err := json.Unmarshal(data, e); err != nil {
+				return err
+			}
+			if e.Status == StatusCompleted && e.CompletedAt != nil && e.CompletedAt.Before(cutoff) {
+				expired = append(expired, id)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		// Deleting while iterating skips keys, so collect first.
+		for _, id := range expired {
+			if err := b.Delete(id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return len(expired), err
+}
+
+func (s *Store) pruneLoop() {
+	for range time.Tick(10 * time.Minute) {
+		deleted, err := s.Prune()
+		if err != nil {
+			log.Error("prune failed", logging.Err(err))
+			continue
+		}
+		metrics.Add("restore_pruned_total", nil, float64(deleted))
+	}
+}
+
+func (s *Store) get(id string) (*Entry, error) {
+	var e *Entry
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(restoresBucket).Get([]byte(id))
+		if data == nil {
+			return nil
+		}
+		e = &Entry{}
+		return json.Unmarshal(data, e)
+	})
+	return e, err
+}
+
+// List returns every entry, the most recently requested first.
+func (s *Store) List() ([]*Entry, error) {
+	var list []*Entry
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(restoresBucket).ForEach(func(_, data []byte) error {
+			e := &Entry{}
+			if err := json.Unmarshal(data, e); err != nil {
+				return err
+			}
+			list = append(list, e)
+			return nil
+		})
+	})
+	sort.Slice(list, func(i, j int) bool { return list[i].RequestedAt.After(list[j].RequestedAt) })
+	return list, err
+}
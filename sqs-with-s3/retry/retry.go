@@ -0,0 +1,110 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// Class tells the consumer what to do with a message whose processing failed.
+type Class int
+
+const (
+	// Requeue returns the message to the queue so a later receive can retry it.
+	// Unclassified errors are treated as Requeue.
+	Requeue Class = iota
+	// Retry marks a transient error (throttling, 5xx) worth retrying in-process.
+	Retry
+	// DeadLetter marks an error that will never succeed, e.g. NoSuchKey or AccessDenied.
+	DeadLetter
+)
+
+func (c Class) String() string {
+	switch c {
+	case Retry:
+		return "retry"
+	case DeadLetter:
+		return "dead-letter"
+	default:
+		return "requeue"
+	}
+}
+
+// Error wraps an error with its Class.
+type Error struct {
+	Class Class
+	Err   error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Classify wraps err with class. A nil err stays nil.
+func Classify(class Class, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Class: class, Err: err}
+}
+
+// ClassOf returns the Class of err, defaulting to Requeue.
+func ClassOf(err error) Class {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Class
+	}
+	return Requeue
+}
+
+// Backoff is a capped exponential backoff with full jitter.
+type Backoff struct {
+	Base     time.Duration
+	Max      time.Duration
+	Attempts int
+}
+
+// Delay returns the jittered wait before the given attempt, counted from zero.
+func (b Backoff) Delay(attempt int) time.Duration {
+	d := b.Base
+	for i := 0; i < attempt && d < b.Max; i++ {
+		d *= 2
+	}
+	if d > b.Max {
+		d = b.Max
+	}
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d)) + 1)
+}
+
+// Do calls fn until it succeeds, returns an error that is not of class Retry,
+// or the attempts run out. When the attempts run out, the last error is
+// reclassified as Requeue so the message goes back to the queue.
+func Do(ctx context.Context, b Backoff, fn func() error) error {
+	attempts := b.Attempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	var err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return Classify(Requeue, ctx.Err())
+			case <-time.After(b.Delay(attempt - 1)):
+			}
+		}
+		err = fn()
+		if err == nil || ClassOf(err) != Retry {
+			return err
+		}
+	}
+	return Classify(Requeue, err)
+}
@@ -0,0 +1,136 @@
+package s3
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/vubon/aws-examples/sqs-with-s3/retry"
+)
+
+// ErrNotArchive is returned by WalkArchive for objects that are neither zip
+// nor tar.
+var ErrNotArchive = errors.New("object is not a zip or tar archive")
+
+var archiveExts = []string{".zip", ".tar", ".tar.gz", ".tgz", ".tar.zst", ".tar.bz2", ".tbz2"}
+
+var zipMagic = []byte("PK\x03\x04")
+
+// Entry is one file inside an archive. Body is only valid during the
+// WalkArchive callback.
+type Entry struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+	Body    io.Reader
+}
+
+// IsArchive reports whether key has a zip or (compressed) tar extension.
+func IsArchive(key string) bool {
+	key = strings.ToLower(key)
+	for _, ext := range archiveExts {
+		if strings.HasSuffix(key, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// WalkArchive calls fn for every regular file in a zip or tar object, in
+// archive order, and stops at the first error fn returns. Tar archives,
+// compressed or not, are streamed. Zip archives keep their index at the end,
+// so they are spooled to a temporary file first; memory use stays bounded
+// either way. The archive is opened with OpenVerified against size and etag.
+func WalkArchive(ctx context.Context, sess *session.Session, bucket, key, versionID string, size int64, etag string, fn func(*Entry) error) error {
+	obj, err := OpenVerified(ctx, sess, bucket, key, versionID, size, etag)
+	if err != nil {
+		return err
+	}
+	defer obj.Body.Close()
+
+	br := bufio.NewReader(obj.Body)
+	head, _ := br.Peek(512)
+	switch {
+	case bytes.HasPrefix(head, zipMagic):
+		return walkZip(br, fn)
+	case len(head) >= 262 && string(head[257:262]) == "ustar":
+		if err := walkTar(br, fn); err != nil {
+			return err
+		}
+		// Read the padding after the end of the archive so the download is
+		// verified to the end.
+		_, err := io.Copy(io.Discard, br)
+		return err
+	}
+	return ErrNotArchive
+}
+
+func walkTar(r io.Reader, fn func(*Entry) error) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return retry.Classify(retry.DeadLetter, fmt.Errorf("read tar: %w", err))
+		}
+		name, ok := entryName(hdr.Name)
+		if hdr.Typeflag != tar.TypeReg || !ok {
+			continue
+		}
+		if err := fn(&Entry{Name: name, Size: hdr.Size, ModTime: hdr.ModTime, Body: tr}); err != nil {
+			return err
+		}
+	}
+}
+
+func walkZip(r io.Reader, fn func(*Entry) error) error {
+	spool, err := os.CreateTemp("", "archive-*.zip")
+	if err != nil {
+		return retry.Classify(retry.Requeue, err)
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+	size, err := io.Copy(spool, r)
+	if err != nil {
+		return Classify(err)
+	}
+	zr, err := zip.NewReader(spool, size)
+	if err != nil {
+		return retry.Classify(retry.DeadLetter, fmt.Errorf("read zip: %w", err))
+	}
+	for _, f := range zr.File {
+		name, ok := entryName(f.Name)
+		if !f.Mode().IsRegular() || !ok {
+			continue
+		}
+		body, err := f.Open()
+		if err != nil {
+			return retry.Classify(retry.DeadLetter, fmt.Errorf("open %s: %w", f.Name, err))
+		}
+		err = fn(&Entry{Name: name, Size: int64(f.UncompressedSize64), ModTime: f.Modified, Body: body})
+		body.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// entryName cleans an entry path so it cannot climb out of the archive, and
+// reports whether anything is left of it.
+func entryName(name string) (string, bool) {
+	name = path.Clean("/" + strings.ReplaceAll(name, "\\", "/"))[1:]
+	return name, name != ""
+}
@@ -0,0 +1,71 @@
+package s3
+
+import (
+	"sync"
+	"time"
+
+	"github.com/vubon/aws-examples/sqs-with-s3/retry"
+)
+
+const (
+	breakerThreshold = 5
+	breakerCooldown  = 30 * time.Second
+)
+
+// breaker is a per-bucket circuit breaker. It opens after breakerThreshold
+// consecutive transient failures and lets a single trial request through once
+// breakerCooldown has passed.
+type breaker struct {
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	trial    bool
+}
+
+var (
+	breakersMu sync.Mutex
+	breakers   = map[string]*breaker{}
+)
+
+func breakerFor(bucket string) *breaker {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+	b, ok := breakers[bucket]
+	if !ok {
+		b = &breaker{}
+		breakers[bucket] = b
+	}
+	return b
+}
+
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < breakerThreshold {
+		return true
+	}
+	if b.trial || time.Since(b.openedAt) < breakerCooldown {
+		return false
+	}
+	b.trial = true
+	return true
+}
+
+// record updates the breaker with the outcome of a request. Dead-letter errors
+// such as NoSuchKey say nothing about the bucket's health and are ignored.
+func (b *breaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+	if err == nil {
+		b.failures = 0
+		return
+	}
+	if retry.ClassOf(err) == retry.DeadLetter {
+		return
+	}
+	b.failures++
+	if b.failures >= breakerThreshold {
+		b.openedAt = time.Now()
+	}
+}
@@ -0,0 +1,156 @@
+package s3
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"io"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/klauspost/compress/zstd"
+	"github.com/vubon/aws-examples/sqs-with-s3/retry"
+)
+
+// codec is a compression the download pipeline removes.
+type codec struct {
+	name   string
+	magic  func(head []byte) bool
+	hints  []string
+	reader func(r io.Reader) (io.ReadCloser, error)
+}
+
+var codecs = []codec{
+	{
+		name:  "gzip",
+		magic: prefix(0x1f, 0x8b),
+		hints: []string{"gzip", "x-gzip", "application/gzip", "application/x-gzip"},
+		reader: func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		},
+	},
+	{
+		name:  "zstd",
+		magic: prefix(0x28, 0xb5, 0x2f, 0xfd),
+		hints: []string{"zstd", "application/zstd"},
+		reader: func(r io.Reader) (io.ReadCloser, error) {
+			zr, err := zstd.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+			return zr.IOReadCloser(), nil
+		},
+	},
+	{
+		name: "bzip2",
+		// "BZh" is followed by the block size, '1' to '9'; text files may
+		// well start with "BZh" alone.
+		magic: func(head []byte) bool {
+			return len(head) >= 4 && string(head[:3]) == "BZh" && head[3] >= '1' && head[3] <= '9'
+		},
+		hints: []string{"bzip2", "application/x-bzip2"},
+		reader: func(r io.Reader) (io.ReadCloser, error) {
+			return io.NopCloser(bzip2.NewReader(r)), nil
+		},
+	},
+}
+
+// prefix matches heads that start with the magic bytes p.
+func prefix(p ...byte) func(head []byte) bool {
+	return func(head []byte) bool {
+		return bytes.HasPrefix(head, p)
+	}
+}
+
+// OpenVerified opens an object for a handler: with parallel ranged GETs when
+// it is large enough, verified against size and etag with Verify, then
+// decrypted and decoded. size and etag may be zero when they are not known.
+func OpenVerified(ctx context.Context, sess *session.Session, bucket, key, versionID string, size int64, etag string) (*Object, error) {
+	var obj *Object
+	var err error
+	if Ranged(size) {
+		obj, err = OpenRanged(ctx, sess, bucket, key, versionID, size, etag)
+	} else {
+		obj, err = OpenObject(ctx, sess, bucket, key, versionID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = Verify(ctx, sess, bucket, key, versionID, obj, size, etag)
+	if err == nil {
+		err = Decrypt(ctx, obj)
+	}
+	if err == nil {
+		err = Decode(obj)
+	}
+	if err != nil {
+		obj.Body.Close()
+		return nil, err
+	}
+	return obj, nil
+}
+
+// Decode replaces the body of a gzip, zstd or bzip2 object with the
+// decompressed stream. The compression is picked from Content-Encoding or
+// Content-Type when the magic bytes agree, since the HTTP client may already
+// have removed a Content-Encoding, and from the magic bytes otherwise.
+// ContentLength is -1 once the body is decoded.
+func Decode(obj *Object) error {
+	br := bufio.NewReader(obj.Body)
+	head, _ := br.Peek(4)
+	c := codecFor(obj.ContentEncoding, obj.ContentType)
+	if c == nil || !c.magic(head) {
+		c = nil
+		for i := range codecs {
+			if codecs[i].magic(head) {
+				c = &codecs[i]
+				break
+			}
+		}
+	}
+	if c == nil {
+		obj.Body = readCloser{br, obj.Body}
+		return nil
+	}
+	r, err := c.reader(br)
+	if err != nil {
+		return retry.Classify(retry.DeadLetter, err)
+	}
+	obj.Body = readCloser{r, multiCloser{r, obj.Body}}
+	obj.Compression = c.name
+	obj.ContentLength = -1
+	return nil
+}
+
+func codecFor(hints ...string) *codec {
+	for _, hint := range hints {
+		hint = strings.ToLower(strings.TrimSpace(strings.SplitN(hint, ";", 2)[0]))
+		for i := range codecs {
+			for _, h := range codecs[i].hints {
+				if hint == h {
+					return &codecs[i]
+				}
+			}
+		}
+	}
+	return nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+type multiCloser []io.Closer
+
+func (m multiCloser) Close() error {
+	var first error
+	for _, c := range m {
+		if err := c.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
@@ -0,0 +1,42 @@
+package s3
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte("hello"))
+	zw.Close()
+	tests := []struct {
+		name        string
+		body        []byte
+		contentType string
+		want        string
+	}{
+		{"plain", []byte("hello"), "text/plain", "hello"},
+		{"gzip", gz.Bytes(), "", "hello"},
+		{"gzip hint", gz.Bytes(), "application/gzip", "hello"},
+		{"text starting with BZh", []byte("BZh is not a block size"), "", "BZh is not a block size"},
+		{"short", []byte("BZ"), "", "BZ"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := &Object{Body: io.NopCloser(bytes.NewReader(tt.body)), ContentType: tt.contentType}
+			if err := Decode(obj); err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(obj.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/vubon/aws-examples/sqs-with-s3/retry"
+)
+
+// Metadata of objects written by the S3 Encryption Client (v2 format).
+const (
+	metaKeyV2   = "X-Amz-Key-V2"
+	metaIV      = "X-Amz-Iv"
+	metaMatDesc = "X-Amz-Matdesc"
+	metaWrapAlg = "X-Amz-Wrap-Alg"
+	metaCEKAlg  = "X-Amz-Cek-Alg"
+	metaTagLen  = "X-Amz-Tag-Len"
+	// metaKeyV1 holds the data key of the v1 format, and metaInstrFile marks
+	// an object whose envelope is kept in an instruction file.
+	metaKeyV1     = "X-Amz-Key"
+	metaInstrFile = "X-Amz-Crypto-Instr-File"
+)
+
+const cekAESGCM = "AES/GCM/NoPadding"
+
+// KeyProvider unwraps the data key of a client-side encrypted object.
+// wrapAlg is the x-amz-wrap-alg of the object, e.g. "kms+context", and
+// matDesc its material description.
+type KeyProvider interface {
+	Unwrap(ctx context.Context, wrapAlg string, wrapped []byte, matDesc map[string]string) ([]byte, error)
+}
+
+// defaultMaxDecryptSize bounds the objects decrypted in memory when no limit
+// is configured.
+const defaultMaxDecryptSize = 64 << 20
+
+var (
+	keyProviderMu  sync.RWMutex
+	keyProvider    KeyProvider
+	maxDecryptSize int64 = defaultMaxDecryptSize
+)
+
+// SetKeyProvider enables client-side decryption of objects of up to maxSize
+// bytes, or 64 MB when maxSize is 0. Without a provider, encrypted objects
+// are handed on as they are stored.
+func SetKeyProvider(p KeyProvider, maxSize int64) {
+	keyProviderMu.Lock()
+	defer keyProviderMu.Unlock()
+	keyProvider = p
+	maxDecryptSize = maxSize
+	if maxSize <= 0 {
+		maxDecryptSize = defaultMaxDecryptSize
+	}
+}
+
+// Decrypt replaces the body of an object encrypted by the S3 Encryption
+// Client with its AES-GCM decrypted content, when a key provider is set. The
+// data key is unwrapped by the provider. The ciphertext is read into memory
+// and authenticated before any plaintext is released, so objects over the
+// size limit are dead-lettered, as are objects that fail authentication.
+// Objects of the v1 format, objects that keep their envelope in an
+// instruction file and AES/CBC content are not supported and are
+// dead-lettered rather than handed on encrypted.
+func Decrypt(ctx context.Context, obj *Object) error {
+	lookup := func(name string) (string, bool) {
+		for k, v := range obj.Metadata {
+			if http.CanonicalHeaderKey(k) == name && v != nil {
+				return *v, true
+			}
+		}
+		return "", false
+	}
+	meta := func(name string) string {
+		v, _ := lookup(name)
+		return v
+	}
+	has := func(name string) bool {
+		_, ok := lookup(name)
+		return ok
+	}
+	keyProviderMu.RLock()
+	provider, maxSize := keyProvider, maxDecryptSize
+	keyProviderMu.RUnlock()
+	if provider == nil {
+		return nil
+	}
+	switch {
+	case meta(metaKeyV2) != "":
+	case meta(metaKeyV1) != "":
+		return retry.Classify(retry.DeadLetter, errors.New("client-side encryption v1 objects are not supported"))
+	case has(metaInstrFile) || has(metaIV):
+		return retry.Classify(retry.DeadLetter, errors.New("client-side encryption instruction files are not supported"))
+	default:
+		return nil
+	}
+
+	if alg := meta(metaCEKAlg); alg != cekAESGCM {
+		return retry.Classify(retry.DeadLetter, fmt.Errorf("unsupported content encryption %q", alg))
+	}
+	if tagLen := meta(metaTagLen); tagLen != "" && tagLen != "128" {
+		return retry.Classify(retry.DeadLetter, fmt.Errorf("unsupported tag length %s", tagLen))
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(meta(metaKeyV2))
+	if err != nil {
+		return retry.Classify(retry.DeadLetter, fmt.Errorf("decode %s: %w", metaKeyV2, err))
+	}
+	iv, err := base64.StdEncoding.DecodeString(meta(metaIV))
+	if err != nil {
+		return retry.Classify(retry.DeadLetter, fmt.Errorf("decode %s: %w", metaIV, err))
+	}
+	matDesc := map[string]string{}
+	if md := meta(metaMatDesc); md != "" {
+		if err := json.Unmarshal([]byte(md), &matDesc); err != nil {
+			return retry.Classify(retry.DeadLetter, fmt.Errorf("decode %s: %w", metaMatDesc, err))
+		}
+	}
+	// kms+context binds the content algorithm to the data key.
+	if alg, ok := matDesc["aws:x-amz-cek-alg"]; ok && alg != cekAESGCM {
+		return retry.Classify(retry.DeadLetter, errors.New("material description does not match the content encryption"))
+	}
+
+	key, err := provider.Unwrap(ctx, meta(metaWrapAlg), wrapped, matDesc)
+	if err != nil {
+		return err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return retry.Classify(retry.DeadLetter, fmt.Errorf("data key: %w", err))
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return retry.Classify(retry.DeadLetter, fmt.Errorf("data key: %w", err))
+	}
+	if len(iv) != aead.NonceSize() {
+		return retry.Classify(retry.DeadLetter, fmt.Errorf("unsupported %d byte IV", len(iv)))
+	}
+	tooLarge := retry.Classify(retry.DeadLetter, fmt.Errorf("encrypted object larger than %d bytes", maxSize))
+	if obj.ContentLength > maxSize+int64(aead.Overhead()) {
+		return tooLarge
+	}
+	// Reading to the end also completes the checks of Verify.
+	sealed, err := io.ReadAll(io.LimitReader(obj.Body, maxSize+int64(aead.Overhead())+1))
+	if err != nil {
+		return Classify(err)
+	}
+	if int64(len(sealed)) > maxSize+int64(aead.Overhead()) {
+		return tooLarge
+	}
+	plain, err := aead.Open(sealed[:0], iv, sealed, nil)
+	if err != nil {
+		return retry.Classify(retry.DeadLetter, fmt.Errorf("decrypt: %w", err))
+	}
+	obj.Body = readCloser{bytes.NewReader(plain), obj.Body}
+	obj.ContentLength = int64(len(plain))
+	return nil
+}
+
+// unwrapAESGCM opens a data key wrapped with "AES/GCM": a 12 byte nonce
+// followed by the sealed key, with the content algorithm as additional data.
+func unwrapAESGCM(kek, wrapped []byte) ([]byte, error) {
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, errors.New("wrapped key too short")
+	}
+	return aead.Open(nil, wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():], []byte(cekAESGCM))
+}
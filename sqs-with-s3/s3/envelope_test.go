@@ -0,0 +1,138 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/vubon/aws-examples/sqs-with-s3/retry"
+)
+
+var testDataKey = bytes.Repeat([]byte{0x42}, 32)
+
+// fixedProvider hands out testDataKey for any wrapped key.
+type fixedProvider struct{}
+
+func (fixedProvider) Unwrap(context.Context, string, []byte, map[string]string) ([]byte, error) {
+	return testDataKey, nil
+}
+
+// sealed returns size bytes of plaintext and an object holding it encrypted
+// in the v2 format.
+func sealed(t *testing.T, size int) ([]byte, *Object) {
+	t.Helper()
+	block, err := aes.NewCipher(testDataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iv := []byte("0123456789ab")
+	plain := make([]byte, size)
+	for i := range plain {
+		plain[i] = byte(i * 7)
+	}
+	body := aead.Seal(nil, iv, plain, nil)
+	return plain, &Object{
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Metadata: aws.StringMap(map[string]string{
+			"x-amz-key-v2":  base64.StdEncoding.EncodeToString([]byte("wrapped")),
+			"x-amz-iv":      base64.StdEncoding.EncodeToString(iv),
+			"x-amz-cek-alg": cekAESGCM,
+		}),
+	}
+}
+
+func TestDecrypt(t *testing.T) {
+	SetKeyProvider(fixedProvider{}, 4096)
+	defer SetKeyProvider(nil, 0)
+	tests := []struct {
+		name   string
+		size   int
+		tamper func(obj *Object)
+		want   retry.Class
+	}{
+		{"empty", 0, nil, -1},
+		{"one block", 16, nil, -1},
+		{"at the limit", 4096, nil, -1},
+		{"over the limit", 4097, nil, retry.DeadLetter},
+		{"over the limit without length", 4097, func(obj *Object) { obj.ContentLength = 0 }, retry.DeadLetter},
+		{"tampered tag", 100, func(obj *Object) {
+			body, _ := io.ReadAll(obj.Body)
+			body[len(body)-1] ^= 1
+			obj.Body = io.NopCloser(bytes.NewReader(body))
+		}, retry.DeadLetter},
+		{"short IV", 100, func(obj *Object) { obj.Metadata["x-amz-iv"] = aws.String("aXY=") }, retry.DeadLetter},
+		{"CBC", 100, func(obj *Object) { obj.Metadata["x-amz-cek-alg"] = aws.String("AES/CBC/PKCS5Padding") }, retry.DeadLetter},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plain, obj := sealed(t, tt.size)
+			if tt.tamper != nil {
+				tt.tamper(obj)
+			}
+			err := Decrypt(context.Background(), obj)
+			if tt.want >= 0 {
+				if err == nil || retry.ClassOf(err) != tt.want {
+					t.Fatalf("got %v, want class %v", err, tt.want)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(obj.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, plain) || obj.ContentLength != int64(len(plain)) {
+				t.Errorf("got %d bytes, content length %d, want the %d byte plaintext", len(got), obj.ContentLength, len(plain))
+			}
+		})
+	}
+}
+
+type nopProvider struct{}
+
+func (nopProvider) Unwrap(context.Context, string, []byte, map[string]string) ([]byte, error) {
+	return nil, errors.New("not called")
+}
+
+func TestDecryptUnsupportedEnvelopes(t *testing.T) {
+	SetKeyProvider(nopProvider{}, 0)
+	defer SetKeyProvider(nil, 0)
+	tests := []struct {
+		name string
+		meta map[string]string
+		want retry.Class
+	}{
+		{"v1", map[string]string{"x-amz-key": "a2V5", "x-amz-iv": "aXY="}, retry.DeadLetter},
+		{"instruction file", map[string]string{"x-amz-crypto-instr-file": ""}, retry.DeadLetter},
+		{"instruction file without marker", map[string]string{"x-amz-iv": "aXY="}, retry.DeadLetter},
+		{"plain", map[string]string{"owner": "me"}, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := &Object{Body: io.NopCloser(bytes.NewReader(nil)), Metadata: aws.StringMap(tt.meta)}
+			err := Decrypt(context.Background(), obj)
+			if tt.want < 0 {
+				if err != nil {
+					t.Fatalf("got %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || retry.ClassOf(err) != tt.want {
+				t.Fatalf("got %v, want class %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package s3
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/vubon/aws-examples/sqs-with-s3/retry"
+)
+
+// ErrCircuitOpen is returned without calling S3 while a bucket's breaker is open.
+var ErrCircuitOpen = errors.New("s3 circuit breaker open")
+
+// Classify tags an S3 error with the retry class the consumer should apply.
+// Missing or since replaced objects and permission errors are dead-lettered,
+// throttling and 5xx responses are retried in-process, and everything else is
+// requeued. Errors that already have a class keep it.
+func Classify(err error) error {
+	if err == nil {
+		return nil
+	}
+	var classified *retry.Error
+	if errors.As(err, &classified) {
+		return err
+	}
+	var aerr awserr.Error
+	if !errors.As(err, &aerr) {
+		return retry.Classify(retry.Requeue, err)
+	}
+	// awserr errors do not unwrap, so look for a class in their causes, e.g.
+	// one raised by a body reader, before the SDK guesses from the code.
+	if inner := origClass(aerr); inner != nil {
+		return retry.Classify(inner.Class, err)
+	}
+	switch aerr.Code() {
+	case s3.ErrCodeNoSuchKey, s3.ErrCodeNoSuchBucket, "NotFound", "AccessDenied",
+		s3.ErrCodeInvalidObjectState, "InvalidRange", "PreconditionFailed", "MethodNotAllowed":
+		return retry.Classify(retry.DeadLetter, err)
+	}
+	// The SDK reports errors it does not know as retryable, so only ask
+	// once the error is known to come from AWS.
+	if request.IsErrorThrottle(aerr) || request.IsErrorRetryable(aerr) {
+		return retry.Classify(retry.Retry, err)
+	}
+	var reqErr awserr.RequestFailure
+	if errors.As(err, &reqErr) {
+		switch status := reqErr.StatusCode(); {
+		case status >= http.StatusInternalServerError:
+			return retry.Classify(retry.Retry, err)
+		case status == http.StatusForbidden || status == http.StatusNotFound:
+			return retry.Classify(retry.DeadLetter, err)
+		}
+	}
+	return retry.Classify(retry.Requeue, err)
+}
+
+// origClass returns the first *retry.Error in the chain of original errors
+// of aerr, or nil.
+func origClass(aerr awserr.Error) *retry.Error {
+	for err := aerr.OrigErr(); err != nil; {
+		var classified *retry.Error
+		if errors.As(err, &classified) {
+			return classified
+		}
+		var next awserr.Error
+		if !errors.As(err, &next) {
+			return nil
+		}
+		err = next.OrigErr()
+	}
+	return nil
+}
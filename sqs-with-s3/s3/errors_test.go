@@ -0,0 +1,45 @@
+package s3
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/vubon/aws-examples/sqs-with-s3/retry"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want retry.Class
+	}{
+		{"dead-letter passthrough", retry.Classify(retry.DeadLetter, errors.New("bad data")), retry.DeadLetter},
+		{"wrapped dead-letter", fmt.Errorf("upload: %w", retry.Classify(retry.DeadLetter, errors.New("bad data"))), retry.DeadLetter},
+		{"requeue passthrough", retry.Classify(retry.Requeue, awserr.New("Throttling", "slow down", nil)), retry.Requeue},
+		{"plain error", errors.New("boom"), retry.Requeue},
+		{"5xx", awserr.NewRequestFailure(awserr.New("InternalError", "oops", nil), http.StatusInternalServerError, "req"), retry.Retry},
+		{"503 slow down", awserr.NewRequestFailure(awserr.New("SlowDown", "slow down", nil), http.StatusServiceUnavailable, "req"), retry.Retry},
+		{"throttling", awserr.New("Throttling", "rate exceeded", nil), retry.Retry},
+		{"no such key", awserr.NewRequestFailure(awserr.New(s3.ErrCodeNoSuchKey, "missing", nil), http.StatusNotFound, "req"), retry.DeadLetter},
+		{"forbidden", awserr.NewRequestFailure(awserr.New("Forbidden", "no", nil), http.StatusForbidden, "req"), retry.DeadLetter},
+		{"dead-letter inside awserr", awserr.New("ReadRequestBody", "read upload data failed", retry.Classify(retry.DeadLetter, errors.New("bad row"))), retry.DeadLetter},
+		{"dead-letter inside nested awserr", awserr.New(request.ErrCodeRead, "read failed", awserr.New("SerializationError", "body", fmt.Errorf("parse: %w", retry.Classify(retry.DeadLetter, errors.New("bad row"))))), retry.DeadLetter},
+		{"unclassified inside awserr", awserr.New(request.ErrCodeRead, "read failed", errors.New("connection reset")), retry.Retry},
+		{"client error", awserr.NewRequestFailure(awserr.New("BadDigest", "digest", nil), http.StatusBadRequest, "req"), retry.Requeue},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retry.ClassOf(Classify(tt.err)); got != tt.want {
+				t.Errorf("Classify(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+	if Classify(nil) != nil {
+		t.Error("Classify(nil) != nil")
+	}
+}
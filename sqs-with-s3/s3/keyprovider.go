@@ -0,0 +1,92 @@
+package s3
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/vubon/aws-examples/sqs-with-s3/retry"
+)
+
+// KeyFileProvider unwraps "AES/GCM" wrapped data keys with a local 256-bit
+// key. It is meant for development; use KMS in production.
+type KeyFileProvider struct {
+	key []byte
+}
+
+// NewKeyFileProvider reads a base64 encoded 256-bit key from path.
+func NewKeyFileProvider(path string) (*KeyFileProvider, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("key file %s: %w", path, err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("key file %s: want a 256-bit key, got %d bytes", path, len(key))
+	}
+	return &KeyFileProvider{key: key}, nil
+}
+
+func (p *KeyFileProvider) Unwrap(_ context.Context, wrapAlg string, wrapped []byte, _ map[string]string) ([]byte, error) {
+	if wrapAlg != "AES/GCM" {
+		return nil, retry.Classify(retry.DeadLetter, fmt.Errorf("key file provider cannot unwrap %q keys", wrapAlg))
+	}
+	key, err := unwrapAESGCM(p.key, wrapped)
+	if err != nil {
+		return nil, retry.Classify(retry.DeadLetter, fmt.Errorf("unwrap data key: %w", err))
+	}
+	return key, nil
+}
+
+// KMSProvider unwraps "kms" and "kms+context" data keys with KMS Decrypt,
+// passing the material description as the encryption context.
+type KMSProvider struct {
+	svc *kms.KMS
+	// keyID, when set, is the only key the provider decrypts with.
+	keyID string
+}
+
+func NewKMSProvider(sess *session.Session, keyID string) *KMSProvider {
+	return &KMSProvider{svc: kms.New(sess), keyID: keyID}
+}
+
+func (p *KMSProvider) Unwrap(ctx context.Context, wrapAlg string, wrapped []byte, matDesc map[string]string) ([]byte, error) {
+	if wrapAlg != "kms" && wrapAlg != "kms+context" {
+		return nil, retry.Classify(retry.DeadLetter, fmt.Errorf("kms provider cannot unwrap %q keys", wrapAlg))
+	}
+	input := &kms.DecryptInput{
+		CiphertextBlob:    wrapped,
+		EncryptionContext: aws.StringMap(matDesc),
+	}
+	if p.keyID != "" {
+		input.KeyId = aws.String(p.keyID)
+	}
+	out, err := p.svc.DecryptWithContext(ctx, input)
+	if err != nil {
+		return nil, classifyKMS(err)
+	}
+	return out.Plaintext, nil
+}
+
+// classifyKMS dead-letters errors that no retry can fix.
+func classifyKMS(err error) error {
+	var aerr awserr.Error
+	if errors.As(err, &aerr) {
+		switch aerr.Code() {
+		case kms.ErrCodeInvalidCiphertextException, kms.ErrCodeIncorrectKeyException, kms.ErrCodeNotFoundException,
+			kms.ErrCodeDisabledException, kms.ErrCodeInvalidKeyUsageException, "AccessDeniedException":
+			return retry.Classify(retry.DeadLetter, err)
+		}
+	}
+	return Classify(err)
+}
@@ -0,0 +1,70 @@
+package s3
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// SourceVersionMeta is the metadata key under which mirrored copies record
+// the source version they were made from.
+const SourceVersionMeta = "source-version-id"
+
+// CopyVersion copies a version of an object to dstBucket/dstKey, keeping its
+// headers and metadata and recording versionID under SourceVersionMeta. On a
+// versioned destination every copy adds a version, so the copies of a key
+// build up its version history. A copy is skipped when the current
+// destination object already came from versionID, e.g. on redelivery; it
+// reports whether it copied and the version of the destination object.
+func CopyVersion(ctx context.Context, sess *session.Session, bucket, key, versionID, dstBucket, dstKey string) (string, bool, error) {
+	svc := s3.New(sess)
+	if versionID != "" {
+		current, err := svc.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(dstBucket),
+			Key:    aws.String(dstKey),
+		})
+		if err == nil && aws.StringValue(current.Metadata[http.CanonicalHeaderKey(SourceVersionMeta)]) == versionID {
+			return aws.StringValue(current.VersionId), false, nil
+		}
+	}
+	src, err := svc.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket:    aws.String(bucket),
+		Key:       aws.String(key),
+		VersionId: version(versionID),
+	})
+	if err != nil {
+		return "", false, Classify(err)
+	}
+	metadata := src.Metadata
+	if metadata == nil {
+		metadata = map[string]*string{}
+	}
+	if versionID != "" {
+		metadata[http.CanonicalHeaderKey(SourceVersionMeta)] = aws.String(versionID)
+	}
+	source := (&url.URL{Path: bucket + "/" + key}).EscapedPath()
+	if versionID != "" {
+		source += "?versionId=" + url.QueryEscape(versionID)
+	}
+	out, err := svc.CopyObjectWithContext(ctx, &s3.CopyObjectInput{
+		Bucket:     aws.String(dstBucket),
+		Key:        aws.String(dstKey),
+		CopySource: aws.String(source),
+		// Replacing the metadata drops the headers unless they are sent again.
+		MetadataDirective:  aws.String(s3.MetadataDirectiveReplace),
+		Metadata:           metadata,
+		ContentType:        src.ContentType,
+		ContentEncoding:    src.ContentEncoding,
+		ContentDisposition: src.ContentDisposition,
+		ContentLanguage:    src.ContentLanguage,
+		CacheControl:       src.CacheControl,
+	})
+	if err != nil {
+		return "", false, Classify(err)
+	}
+	return aws.StringValue(out.VersionId), true, nil
+}
@@ -0,0 +1,315 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/vubon/aws-examples/sqs-with-s3/config"
+	"github.com/vubon/aws-examples/sqs-with-s3/metrics"
+	"github.com/vubon/aws-examples/sqs-with-s3/retry"
+)
+
+var (
+	downloadsMu sync.RWMutex
+	downloads   *config.Downloads
+)
+
+// Configure enables parallel ranged downloads. A nil d disables them.
+func Configure(d *config.Downloads) {
+	downloadsMu.Lock()
+	defer downloadsMu.Unlock()
+	downloads = d
+}
+
+// Ranged reports whether an object of size bytes is downloaded in parts.
+func Ranged(size int64) bool {
+	downloadsMu.RLock()
+	defer downloadsMu.RUnlock()
+	return downloads != nil && size >= int64(downloads.ThresholdMB)<<20
+}
+
+// OpenRanged downloads an object of the given size and ETag, both from the
+// event, with concurrent ranged GETs. The first part must match the event's
+// size and ETag, the others are requested with If-Match on it, and every part
+// must return exactly its range, so no part is taken from a newer version of
+// the object. A failed part is retried on its own.
+func OpenRanged(ctx context.Context, sess *session.Session, bucket, key, versionID string, size int64, etag string) (*Object, error) {
+	downloadsMu.RLock()
+	d := *downloads
+	downloadsMu.RUnlock()
+
+	br := breakerFor(bucket)
+	if !br.allow() {
+		return nil, retry.Classify(retry.Requeue, fmt.Errorf("bucket %s: %w", bucket, ErrCircuitOpen))
+	}
+	svc := s3.New(sess)
+	// The first part also answers the object's metadata.
+	partSize := int64(d.PartSizeMB) << 20
+	first, obj, err := fetchPart(ctx, svc, bucket, key, versionID, "", 0, minInt64(partSize, size)-1)
+	if err == nil && (obj.ContentLength != size || etag != "" && strings.Trim(obj.ETag, `"`) != strings.Trim(etag, `"`)) {
+		err = retry.Classify(retry.DeadLetter, fmt.Errorf("object changed since the event: size %d etag %s, want %d %s", obj.ContentLength, obj.ETag, size, etag))
+	}
+	if err != nil {
+		br.record(err)
+		return nil, err
+	}
+	p := &parts{
+		ctx:      ctx,
+		svc:      svc,
+		bucket:   bucket,
+		key:      key,
+		version:  versionID,
+		etag:     obj.ETag,
+		size:     size,
+		partSize: partSize,
+		record:   br.record,
+	}
+	if d.TempFile {
+		obj.Body, err = p.toFile(first, d.Concurrency)
+	} else {
+		obj.Body = p.stream(first, d.Concurrency)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// fetchPart reads bytes start to end (inclusive) of the object with retries.
+// The returned object's ContentLength is the size of the whole object.
+func fetchPart(ctx context.Context, svc *s3.S3, bucket, key, versionID, etag string, start, end int64) ([]byte, *Object, error) {
+	var data []byte
+	var obj *Object
+	err := retry.Do(ctx, downloadBackoff, func() error {
+		input := &s3.GetObjectInput{
+			Bucket:    aws.String(bucket),
+			Key:       aws.String(key),
+			VersionId: version(versionID),
+			Range:     aws.String(fmt.Sprintf("bytes=%d-%d", start, end)),
+		}
+		if etag != "" {
+			input.IfMatch = aws.String(etag)
+		}
+		rawObject, err := svc.GetObjectWithContext(ctx, input)
+		if err != nil {
+			return Classify(err)
+		}
+		defer rawObject.Body.Close()
+		// A server or proxy ignoring the Range header answers with the whole
+		// object, which must not be taken for the part.
+		gotStart, gotEnd, total, ok := parseContentRange(aws.StringValue(rawObject.ContentRange))
+		if !ok || gotStart != start || gotEnd != end {
+			metrics.Inc("s3_part_errors_total", metrics.Labels{"bucket": bucket})
+			return retry.Classify(retry.Retry, fmt.Errorf("part %d-%d: got content range %q", start, end, aws.StringValue(rawObject.ContentRange)))
+		}
+		data = make([]byte, end-start+1)
+		n, err := io.ReadFull(rawObject.Body, data)
+		if err != nil {
+			metrics.Inc("s3_part_errors_total", metrics.Labels{"bucket": bucket})
+			return retry.Classify(retry.Retry, fmt.Errorf("part %d-%d: read %d bytes: %w", start, end, n, err))
+		}
+		obj = objectFrom(rawObject)
+		obj.Body = nil
+		obj.ContentLength = total
+		return nil
+	})
+	return data, obj, err
+}
+
+type parts struct {
+	ctx      context.Context
+	svc      *s3.S3
+	bucket   string
+	key      string
+	version  string
+	etag     string
+	size     int64
+	partSize int64
+	record   func(error)
+}
+
+func (p *parts) count() int {
+	return int((p.size + p.partSize - 1) / p.partSize)
+}
+
+func (p *parts) fetch(ctx context.Context, i int) ([]byte, error) {
+	start := int64(i) * p.partSize
+	end := minInt64(start+p.partSize, p.size) - 1
+	data, _, err := fetchPart(ctx, p.svc, p.bucket, p.key, p.version, p.etag, start, end)
+	return data, err
+}
+
+// toFile writes every part to a temporary file at its offset and returns the
+// file, which is removed on Close.
+func (p *parts) toFile(first []byte, concurrency int) (io.ReadCloser, error) {
+	f, err := os.CreateTemp("", "download-*")
+	if err != nil {
+		return nil, retry.Classify(retry.Requeue, err)
+	}
+	body := &tempFile{f}
+	if _, err := f.WriteAt(first, 0); err != nil {
+		body.Close()
+		return nil, retry.Classify(retry.Requeue, err)
+	}
+
+	ctx, cancel := context.WithCancel(p.ctx)
+	defer cancel()
+	var (
+		wg   sync.WaitGroup
+		once sync.Once
+		fail error
+	)
+	sem := make(chan struct{}, concurrency)
+	for i := 1; i < p.count() && ctx.Err() == nil; i++ {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int) {
+			defer func() { <-sem; wg.Done() }()
+			data, err := p.fetch(ctx, i)
+			if err == nil {
+				_, err = f.WriteAt(data, int64(i)*p.partSize)
+			}
+			if err != nil {
+				once.Do(func() { fail = err; cancel() })
+			}
+		}(i)
+	}
+	wg.Wait()
+	p.record(fail)
+	if fail == nil && p.ctx.Err() != nil {
+		fail = retry.Classify(retry.Requeue, p.ctx.Err())
+	}
+	if fail != nil {
+		body.Close()
+		return nil, fail
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		body.Close()
+		return nil, retry.Classify(retry.Requeue, err)
+	}
+	return body, nil
+}
+
+type tempFile struct {
+	*os.File
+}
+
+func (t *tempFile) Close() error {
+	err := t.File.Close()
+	os.Remove(t.Name())
+	return err
+}
+
+type partResult struct {
+	data []byte
+	err  error
+}
+
+// stream fetches parts ahead of the reader, at most concurrency of them
+// fetched or waiting to be read at once, and returns them in order.
+func (p *parts) stream(first []byte, concurrency int) io.ReadCloser {
+	ctx, cancel := context.WithCancel(p.ctx)
+	r := &orderedReader{
+		ctx:     ctx,
+		cancel:  cancel,
+		buf:     first,
+		results: make([]chan partResult, p.count()),
+		slots:   make(chan struct{}, concurrency),
+		record:  p.record,
+	}
+	for i := range r.results {
+		r.results[i] = make(chan partResult, 1)
+	}
+	r.next = 1
+	go func() {
+		for i := 1; i < p.count(); i++ {
+			select {
+			case r.slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			go func(i int) {
+				data, err := p.fetch(ctx, i)
+				r.results[i] <- partResult{data, err}
+			}(i)
+		}
+	}()
+	return r
+}
+
+type orderedReader struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	buf     []byte
+	results []chan partResult
+	next    int
+	slots   chan struct{}
+	record  func(error)
+	err     error
+}
+
+func (r *orderedReader) Read(b []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if r.next == len(r.results) {
+			r.err = io.EOF
+			r.record(nil)
+			continue
+		}
+		var res partResult
+		select {
+		case res = <-r.results[r.next]:
+		case <-r.ctx.Done():
+			// The handler gave up; a part still being fetched is not an S3 failure.
+			r.err = r.ctx.Err()
+			continue
+		}
+		<-r.slots
+		r.next++
+		if res.err != nil {
+			r.err = res.err
+			r.record(res.err)
+			r.cancel()
+			continue
+		}
+		r.buf = res.data
+	}
+	n := copy(b, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *orderedReader) Close() error {
+	if r.err == nil {
+		// Closed early without a failed part.
+		r.record(nil)
+	}
+	r.cancel()
+	return nil
+}
+
+// parseContentRange parses the first and last byte and the object size from
+// a Content-Range such as "bytes 0-99/1000".
+func parseContentRange(contentRange string) (start, end, total int64, ok bool) {
+	n, err := fmt.Sscanf(contentRange, "bytes %d-%d/%d", &start, &end, &total)
+	if err != nil || n != 3 || start < 0 || start > end || end >= total {
+		return 0, 0, 0, false
+	}
+	return start, end, total, true
+}
+
+func minInt64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,105 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// TagProcessed sets processed=true and processed-at on the object version,
+// keeping its other tags.
+func TagProcessed(sess *session.Session, bucket, key, versionID string, at time.Time) error {
+	svc := s3.New(sess)
+	current, err := svc.GetObjectTagging(&s3.GetObjectTaggingInput{
+		Bucket:    aws.String(bucket),
+		Key:       aws.String(key),
+		VersionId: version(versionID),
+	})
+	if err != nil {
+		return Classify(err)
+	}
+	tags := []*s3.Tag{
+		{Key: aws.String("processed"), Value: aws.String("true")},
+		{Key: aws.String("processed-at"), Value: aws.String(at.UTC().Format(time.RFC3339))},
+	}
+	for _, tag := range current.TagSet {
+		if k := aws.StringValue(tag.Key); k != "processed" && k != "processed-at" {
+			tags = append(tags, tag)
+		}
+	}
+	_, err = svc.PutObjectTagging(&s3.PutObjectTaggingInput{
+		Bucket:    aws.String(bucket),
+		Key:       aws.String(key),
+		VersionId: version(versionID),
+		Tagging:   &s3.Tagging{TagSet: tags},
+	})
+	return Classify(err)
+}
+
+// Delete removes the object, or the version when versionID is set.
+func Delete(ctx context.Context, sess *session.Session, bucket, key, versionID string) error {
+	_, err := s3.New(sess).DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket:    aws.String(bucket),
+		Key:       aws.String(key),
+		VersionId: version(versionID),
+	})
+	return Classify(err)
+}
+
+// Archive copies the object to dstBucket/dstKey with the given storage class,
+// or the bucket default when it is empty, then deletes the original. With a
+// versionID that version is copied; it is then permanently deleted only with
+// deleteVersion, and otherwise hidden behind a delete marker so the version
+// history of a versioned bucket is kept. The delete marker would hide the
+// current version, so it is only added while versionID is still current; a
+// newer upload is left for its own event. CopyObject handles objects up to 5 GB.
+func Archive(sess *session.Session, bucket, key, versionID, dstBucket, dstKey, storageClass string, deleteVersion bool) error {
+	svc := s3.New(sess)
+	source := (&url.URL{Path: bucket + "/" + key}).EscapedPath()
+	if versionID != "" {
+		source += "?versionId=" + url.QueryEscape(versionID)
+	}
+	input := &s3.CopyObjectInput{
+		Bucket:     aws.String(dstBucket),
+		Key:        aws.String(dstKey),
+		CopySource: aws.String(source),
+	}
+	if storageClass != "" {
+		input.StorageClass = aws.String(storageClass)
+	}
+	if _, err := svc.CopyObject(input); err != nil {
+		return Classify(err)
+	}
+	del := &s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	}
+	if deleteVersion {
+		del.VersionId = version(versionID)
+	} else if versionID != "" {
+		current, err := svc.HeadObject(&s3.HeadObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		})
+		var reqErr awserr.RequestFailure
+		if errors.As(err, &reqErr) && reqErr.StatusCode() == http.StatusNotFound {
+			// The current version is already a delete marker.
+			return nil
+		}
+		if err != nil {
+			return Classify(err)
+		}
+		if aws.StringValue(current.VersionId) != versionID {
+			return nil
+		}
+	}
+	_, err := svc.DeleteObject(del)
+	return Classify(err)
+}
@@ -0,0 +1,35 @@
+package s3
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/vubon/aws-examples/sqs-with-s3/s3test"
+)
+
+func TestArchive(t *testing.T) {
+	tests := []struct {
+		name          string
+		versionID     string
+		current       string
+		deleteVersion bool
+		ops           []string
+	}{
+		{"unversioned", "", "", false, []string{"CopyObject archive/k", "DeleteObject src/k"}},
+		{"current version", "v1", "v1", false, []string{"CopyObject archive/k", "HeadObject src/k", "DeleteObject src/k"}},
+		{"newer upload", "v1", "v2", false, []string{"CopyObject archive/k", "HeadObject src/k"}},
+		{"delete version", "v1", "v2", true, []string{"CopyObject archive/k", "DeleteObject src/k"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := s3test.NewServer(t)
+			srv.Put("src", "k", &s3test.Object{Body: []byte("data"), VersionID: tt.current})
+			if err := Archive(srv.Session(), "src", "k", tt.versionID, "archive", "k", "", tt.deleteVersion); err != nil {
+				t.Fatal(err)
+			}
+			if got := srv.Ops(); !reflect.DeepEqual(got, tt.ops) {
+				t.Errorf("operations %v, want %v", got, tt.ops)
+			}
+		})
+	}
+}
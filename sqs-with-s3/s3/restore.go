@@ -0,0 +1,37 @@
+package s3
+
+import (
+	"context"
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// IsArchived reports whether err is S3 refusing to read an object that is in
+// an archive storage class such as GLACIER or DEEP_ARCHIVE and not restored.
+func IsArchived(err error) bool {
+	var aerr awserr.Error
+	return errors.As(err, &aerr) && aerr.Code() == s3.ErrCodeInvalidObjectState
+}
+
+// Restore asks S3 to restore an archived object version for days with the
+// given retrieval tier. A restore already in progress is not an error.
+func Restore(ctx context.Context, sess *session.Session, bucket, key, versionID, tier string, days int) error {
+	_, err := s3.New(sess).RestoreObjectWithContext(ctx, &s3.RestoreObjectInput{
+		Bucket:    aws.String(bucket),
+		Key:       aws.String(key),
+		VersionId: version(versionID),
+		RestoreRequest: &s3.RestoreRequest{
+			Days:                 aws.Int64(int64(days)),
+			GlacierJobParameters: &s3.GlacierJobParameters{Tier: aws.String(tier)},
+		},
+	})
+	var aerr awserr.Error
+	if errors.As(err, &aerr) && aerr.Code() == "RestoreAlreadyInProgress" {
+		return nil
+	}
+	return Classify(err)
+}
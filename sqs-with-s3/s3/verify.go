@@ -0,0 +1,218 @@
+package s3
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/vubon/aws-examples/sqs-with-s3/metrics"
+	"github.com/vubon/aws-examples/sqs-with-s3/retry"
+)
+
+// ErrIntegrity is wrapped by every verification failure.
+var ErrIntegrity = errors.New("s3 object integrity check failed")
+
+// Verify checks a freshly opened object against the size and ETag of its
+// event, and wraps the body so that reading it to the end also checks:
+//
+//   - the number of bytes against the size,
+//   - the MD5 of single-part uploads against the ETag,
+//   - the composite ETag of multipart uploads, when every part but the last
+//     has the size of the first,
+//   - the SHA-256 and CRC32C additional checksums returned for the object.
+//
+// SSE-KMS and SSE-C objects have no MD5 ETag and only get the other checks.
+// An object whose ETag or size differs from the event up front is not the
+// version the event describes and is dead-lettered. A failed check of the
+// body is classified to requeue the message, since a retried download may
+// succeed, and the reader returns it instead of io.EOF. size and etag may be
+// zero to skip them.
+func Verify(ctx context.Context, sess *session.Session, bucket, key, versionID string, obj *Object, size int64, etag string) error {
+	if etag != "" && trimETag(obj.ETag) != trimETag(etag) {
+		return integrityError(bucket, "etag", retry.DeadLetter, fmt.Errorf("etag %s, event has %s", obj.ETag, etag))
+	}
+	if size > 0 && obj.ContentLength >= 0 && obj.ContentLength != size {
+		return integrityError(bucket, "size", retry.DeadLetter, fmt.Errorf("size %d, event has %d", obj.ContentLength, size))
+	}
+	if size <= 0 {
+		size = obj.ContentLength
+	}
+
+	v := &verifier{body: obj.Body, bucket: bucket, size: size}
+	etag = trimETag(obj.ETag)
+	dash := strings.IndexByte(etag, '-')
+	switch {
+	case obj.Encryption == "aws:kms" || obj.Encryption == "aws:kms:dsse" || obj.Encryption == "SSE-C":
+	case dash < 0:
+		v.add("md5", md5.New(), etag, hex.EncodeToString)
+	default:
+		parts, err := strconv.Atoi(etag[dash+1:])
+		if err != nil {
+			break
+		}
+		partSize, err := uniformPartSize(ctx, sess, bucket, key, versionID, size, parts)
+		if err != nil {
+			return err
+		}
+		if partSize > 0 {
+			v.partSize = partSize
+			v.parts = md5.New()
+			v.add("etag", md5.New(), etag, func(sum []byte) string {
+				return hex.EncodeToString(sum) + "-" + strconv.Itoa(parts)
+			})
+		}
+	}
+	// Multipart checksums ("...-N") cover the part checksums, not the data.
+	if c := obj.ChecksumSHA256; c != "" && !strings.Contains(c, "-") {
+		v.add("sha256", sha256.New(), c, base64.StdEncoding.EncodeToString)
+	}
+	if c := obj.ChecksumCRC32C; c != "" && !strings.Contains(c, "-") {
+		v.add("crc32c", crc32.New(crc32.MakeTable(crc32.Castagnoli)), c, base64.StdEncoding.EncodeToString)
+	}
+	obj.Body = v
+	return nil
+}
+
+// uniformPartSize returns the size of the first part of a multipart object
+// when the last part confirms that all the others have that size, or 0 when
+// the part sizes cannot be known.
+func uniformPartSize(ctx context.Context, sess *session.Session, bucket, key, versionID string, size int64, parts int) (int64, error) {
+	svc := s3.New(sess)
+	partLength := func(n int) (int64, error) {
+		var length int64
+		err := retry.Do(ctx, downloadBackoff, func() error {
+			out, err := svc.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+				Bucket:     aws.String(bucket),
+				Key:        aws.String(key),
+				VersionId:  version(versionID),
+				PartNumber: aws.Int64(int64(n)),
+			})
+			if err != nil {
+				return Classify(err)
+			}
+			length = aws.Int64Value(out.ContentLength)
+			return nil
+		})
+		return length, err
+	}
+	first, err := partLength(1)
+	if err != nil || parts == 1 {
+		return first, err
+	}
+	last, err := partLength(parts)
+	if err != nil {
+		return 0, err
+	}
+	if first*int64(parts-1)+last != size {
+		return 0, nil
+	}
+	return first, nil
+}
+
+type check struct {
+	name   string
+	hash   hash.Hash
+	want   string
+	encode func([]byte) string
+}
+
+// verifier hashes the body as it is read and checks it at io.EOF.
+type verifier struct {
+	body   io.ReadCloser
+	bucket string
+	size   int64
+	read   int64
+	checks []check
+	// parts hashes the current part of a multipart object; its sums are
+	// written to the "etag" check at every part boundary.
+	parts    hash.Hash
+	partSize int64
+	err      error
+}
+
+func (v *verifier) add(name string, h hash.Hash, want string, encode func([]byte) string) {
+	v.checks = append(v.checks, check{name, h, want, encode})
+}
+
+func (v *verifier) Read(p []byte) (int, error) {
+	if v.err != nil {
+		return 0, v.err
+	}
+	n, err := v.body.Read(p)
+	v.write(p[:n])
+	if err == io.EOF {
+		err = v.finish()
+		if err == nil {
+			err = io.EOF
+		}
+		v.err = err
+	}
+	return n, err
+}
+
+func (v *verifier) write(p []byte) {
+	for _, c := range v.checks {
+		if c.name != "etag" {
+			c.hash.Write(p)
+		}
+	}
+	for v.parts != nil && len(p) > 0 {
+		n := minInt64(int64(len(p)), v.partSize-v.read%v.partSize)
+		v.parts.Write(p[:n])
+		v.read += n
+		p = p[n:]
+		if v.read%v.partSize == 0 {
+			v.endPart()
+		}
+	}
+	v.read += int64(len(p))
+}
+
+func (v *verifier) endPart() {
+	for _, c := range v.checks {
+		if c.name == "etag" {
+			c.hash.Write(v.parts.Sum(nil))
+		}
+	}
+	v.parts.Reset()
+}
+
+func (v *verifier) finish() error {
+	if v.size >= 0 && v.read != v.size {
+		return integrityError(v.bucket, "size", retry.Requeue, fmt.Errorf("read %d bytes, want %d", v.read, v.size))
+	}
+	if v.parts != nil && v.read%v.partSize != 0 {
+		v.endPart()
+	}
+	for _, c := range v.checks {
+		if got := c.encode(c.hash.Sum(nil)); got != c.want {
+			return integrityError(v.bucket, c.name, retry.Requeue, fmt.Errorf("%s %s, want %s", c.name, got, c.want))
+		}
+	}
+	return nil
+}
+
+func (v *verifier) Close() error {
+	return v.body.Close()
+}
+
+func integrityError(bucket, check string, class retry.Class, err error) error {
+	metrics.Inc("s3_integrity_failures_total", metrics.Labels{"bucket": bucket, "check": check})
+	return retry.Classify(class, fmt.Errorf("%w: %v", ErrIntegrity, err))
+}
+
+func trimETag(etag string) string {
+	return strings.Trim(etag, `"`)
+}
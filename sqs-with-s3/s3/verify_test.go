@@ -0,0 +1,126 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/vubon/aws-examples/sqs-with-s3/retry"
+)
+
+func md5Hex(p []byte) string {
+	sum := md5.Sum(p)
+	return hex.EncodeToString(sum[:])
+}
+
+// compositeETag is the ETag of a multipart upload of body in parts of size.
+func compositeETag(body []byte, size int) string {
+	var sums []byte
+	parts := 0
+	for ; len(body) > 0; parts++ {
+		n := min(size, len(body))
+		sum := md5.Sum(body[:n])
+		sums = append(sums, sum[:]...)
+		body = body[n:]
+	}
+	return `"` + md5Hex(sums) + "-" + strconv.Itoa(parts) + `"`
+}
+
+func TestVerify(t *testing.T) {
+	body := []byte("0123456789")
+	corrupt := []byte("0123456789")
+	corrupt[5] = 'x'
+	sha := sha256.Sum256(body)
+	tests := []struct {
+		name string
+		obj  Object
+		// read is the body served, body by default.
+		read []byte
+		// size and etag are the event's.
+		size int64
+		etag string
+		// parts are the part sizes HeadObject reports.
+		parts []int64
+		// open is the class Verify fails with, and eof the class of reading
+		// the body to the end.
+		open, eof retry.Class
+	}{
+		{name: "md5", obj: Object{ETag: `"` + md5Hex(body) + `"`}, size: 10, etag: md5Hex(body), open: -1, eof: -1},
+		{name: "md5 mismatch", obj: Object{ETag: `"` + md5Hex(body) + `"`}, read: corrupt, open: -1, eof: retry.Requeue},
+		{name: "event etag", obj: Object{ETag: `"` + md5Hex(body) + `"`}, etag: "other", open: retry.DeadLetter},
+		{name: "event size", obj: Object{ETag: `"` + md5Hex(body) + `"`}, size: 11, open: retry.DeadLetter},
+		{name: "short body", obj: Object{ETag: `"` + md5Hex(body) + `"`, ContentLength: -1}, size: 11, open: -1, eof: retry.Requeue},
+		{name: "kms", obj: Object{ETag: `"` + md5Hex(nil) + `"`, Encryption: "aws:kms"}, open: -1, eof: -1},
+		{name: "multipart", obj: Object{ETag: compositeETag(body, 4)}, parts: []int64{4, 4, 2}, open: -1, eof: -1},
+		{name: "multipart mismatch", obj: Object{ETag: compositeETag(body, 4)}, read: corrupt, parts: []int64{4, 4, 2}, open: -1, eof: retry.Requeue},
+		// Parts of 4 and a last part of 3 do not add up to 10 bytes, so the
+		// sizes of the middle parts are unknown and the ETag is not checked.
+		{name: "multipart uneven", obj: Object{ETag: compositeETag(body, 4)}, read: corrupt, parts: []int64{4, 4, 3}, open: -1, eof: -1},
+		{name: "one part", obj: Object{ETag: compositeETag(body, 10)}, parts: []int64{10}, open: -1, eof: -1},
+		{name: "sha256", obj: Object{Encryption: "SSE-C", ChecksumSHA256: base64.StdEncoding.EncodeToString(sha[:])}, open: -1, eof: -1},
+		{name: "sha256 mismatch", obj: Object{Encryption: "SSE-C", ChecksumSHA256: base64.StdEncoding.EncodeToString(sha[:])}, read: corrupt, open: -1, eof: retry.Requeue},
+		{name: "multipart sha256", obj: Object{Encryption: "SSE-C", ChecksumSHA256: "c2hh-3"}, read: corrupt, open: -1, eof: -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n, err := strconv.Atoi(r.URL.Query().Get("partNumber"))
+				if r.Method != http.MethodHead || err != nil || n < 1 || n > len(tt.parts) {
+					http.NotFound(w, r)
+					return
+				}
+				w.Header().Set("Content-Length", strconv.FormatInt(tt.parts[n-1], 10))
+			}))
+			defer srv.Close()
+			sess := session.Must(session.NewSession(&aws.Config{
+				Region:           aws.String("us-east-1"),
+				Endpoint:         aws.String(srv.URL),
+				S3ForcePathStyle: aws.Bool(true),
+				Credentials:      credentials.NewStaticCredentials("id", "secret", ""),
+				MaxRetries:       aws.Int(0),
+			}))
+
+			read := tt.read
+			if read == nil {
+				read = body
+			}
+			obj := tt.obj
+			obj.Body = io.NopCloser(bytes.NewReader(read))
+			if obj.ContentLength == 0 {
+				obj.ContentLength = int64(len(read))
+			}
+			err := Verify(context.Background(), sess, "b", "k", "", &obj, tt.size, tt.etag)
+			if tt.open >= 0 {
+				if err == nil || retry.ClassOf(err) != tt.open || !errors.Is(err, ErrIntegrity) {
+					t.Fatalf("Verify: got %v, want class %v", err, tt.open)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Verify: %v", err)
+			}
+			got, err := io.ReadAll(obj.Body)
+			if tt.eof >= 0 {
+				if err == nil || retry.ClassOf(err) != tt.eof || !errors.Is(err, ErrIntegrity) {
+					t.Fatalf("read: got %v, want class %v", err, tt.eof)
+				}
+				return
+			}
+			if err != nil || !bytes.Equal(got, read) {
+				t.Fatalf("read %q, %v", got, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+// Package s3test runs an in-memory S3 endpoint for tests of code that talks
+// to S3 through a session.
+package s3test
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// Object is a stored object.
+type Object struct {
+	Body        []byte
+	ContentType string
+	Metadata    map[string]string
+	Tags        string
+	// VersionID is returned as the version of the object, when set.
+	VersionID string
+}
+
+// ETag is the quoted MD5 of the body, as S3 returns it for single part uploads.
+func (o *Object) ETag() string {
+	sum := md5.Sum(o.Body)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// Server is a path-style S3 endpoint holding objects in memory. It serves
+// GetObject, HeadObject, PutObject, CopyObject, DeleteObject and object
+// tagging, and records the operations it was asked for.
+type Server struct {
+	mu      sync.Mutex
+	objects map[string]*Object
+	ops     []string
+	srv     *httptest.Server
+}
+
+// NewServer starts a server that is closed when the test ends.
+func NewServer(t *testing.T) *Server {
+	s := &Server{objects: map[string]*Object{}}
+	s.srv = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.srv.Close)
+	return s
+}
+
+// Session returns a session whose S3 requests go to the server.
+func (s *Server) Session() *session.Session {
+	return session.Must(session.NewSession(&aws.Config{
+		Region:           aws.String("us-east-1"),
+		Endpoint:         aws.String(s.srv.URL),
+		S3ForcePathStyle: aws.Bool(true),
+		Credentials:      credentials.NewStaticCredentials("id", "secret", ""),
+		MaxRetries:       aws.Int(0),
+	}))
+}
+
+// Put stores an object.
+func (s *Server) Put(bucket, key string, obj *Object) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[bucket+"/"+key] = obj
+}
+
+// Get returns a stored object, or nil.
+func (s *Server) Get(bucket, key string) *Object {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.objects[bucket+"/"+key]
+}
+
+// Ops returns the operations served so far, e.g. "PutObject bucket/key".
+func (s *Server) Ops() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.ops...)
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	query := r.URL.Query()
+	_, tagging := query["tagging"]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record := func(op string) { s.ops = append(s.ops, op+" "+path) }
+	obj := s.objects[path]
+
+	switch {
+	case r.Method == http.MethodGet && tagging:
+		record("GetObjectTagging")
+		if obj == nil {
+			notFound(w)
+			return
+		}
+		fmt.Fprintf(w, "<Tagging><TagSet>%s</TagSet></Tagging>", obj.Tags)
+	case r.Method == http.MethodPut && tagging:
+		record("PutObjectTagging")
+		if obj == nil {
+			notFound(w)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		tags := string(body)
+		if i, j := strings.Index(tags, "<TagSet>"), strings.Index(tags, "</TagSet>"); i >= 0 && j > i {
+			tags = tags[i+len("<TagSet>") : j]
+		}
+		obj.Tags = tags
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		if r.Method == http.MethodGet {
+			record("GetObject")
+		} else {
+			record("HeadObject")
+		}
+		if obj == nil {
+			notFound(w)
+			return
+		}
+		w.Header().Set("ETag", obj.ETag())
+		w.Header().Set("Content-Type", obj.ContentType)
+		if obj.VersionID != "" {
+			w.Header().Set("X-Amz-Version-Id", obj.VersionID)
+		}
+		for k, v := range obj.Metadata {
+			w.Header().Set("X-Amz-Meta-"+k, v)
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(obj.Body)))
+		if r.Method == http.MethodGet {
+			w.Write(obj.Body)
+		}
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		record("CopyObject")
+		source, _ := url.PathUnescape(strings.SplitN(r.Header.Get("X-Amz-Copy-Source"), "?", 2)[0])
+		src := s.objects[strings.TrimPrefix(source, "/")]
+		if src == nil {
+			notFound(w)
+			return
+		}
+		dst := *src
+		s.objects[path] = &dst
+		fmt.Fprintf(w, "<CopyObjectResult><ETag>%s</ETag></CopyObjectResult>", dst.ETag())
+	case r.Method == http.MethodPut:
+		record("PutObject")
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		obj := &Object{Body: body, ContentType: r.Header.Get("Content-Type"), Metadata: map[string]string{}}
+		for k := range r.Header {
+			if strings.HasPrefix(k, "X-Amz-Meta-") {
+				obj.Metadata[strings.ToLower(strings.TrimPrefix(k, "X-Amz-Meta-"))] = r.Header.Get(k)
+			}
+		}
+		s.objects[path] = obj
+		w.Header().Set("ETag", obj.ETag())
+	case r.Method == http.MethodDelete:
+		record("DeleteObject")
+		delete(s.objects, path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "not implemented", http.StatusNotImplemented)
+	}
+}
+
+func notFound(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusNotFound)
+	fmt.Fprint(w, "<Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message></Error>")
+}
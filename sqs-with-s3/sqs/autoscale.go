@@ -0,0 +1,78 @@
+package sqs
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/vubon/aws-examples/sqs-with-s3/config"
+	"github.com/vubon/aws-examples/sqs-with-s3/logging"
+	"github.com/vubon/aws-examples/sqs-with-s3/metrics"
+)
+
+// autoscale periodically sizes the worker pool to the queue backlog.
+func (c *Consumer) autoscale() {
+	s := &scaler{cfg: c.cfg.Autoscale}
+	for range time.Tick(time.Duration(s.cfg.IntervalSeconds) * time.Second) {
+		backlog, err := c.backlog()
+		if err != nil {
+			c.log.Error("autoscale queue attributes failed", logging.Err(err))
+			continue
+		}
+		target, resize := s.next(backlog, c.Workers())
+		metrics.Set("sqs_workers_target", c.labels, float64(target))
+		if resize {
+			c.SetWorkers(target)
+		}
+	}
+}
+
+// scaler picks worker counts from backlog readings. It scales up as soon as
+// the backlog asks for more workers, but only scales down after the backlog
+// has stayed low for ScaleDownChecks readings in a row.
+type scaler struct {
+	cfg *config.Autoscale
+	low int
+}
+
+// next returns the worker count the backlog asks for, and whether the pool
+// of current workers should be resized to it.
+func (s *scaler) next(backlog, current int) (int, bool) {
+	target := (backlog + s.cfg.MessagesPerWorker - 1) / s.cfg.MessagesPerWorker
+	target = min(max(target, s.cfg.MinWorkers), s.cfg.MaxWorkers)
+	switch {
+	case target > current:
+		s.low = 0
+		return target, true
+	case target < current:
+		s.low++
+		if s.low >= s.cfg.ScaleDownChecks {
+			s.low = 0
+			return target, true
+		}
+	default:
+		s.low = 0
+	}
+	return target, false
+}
+
+// backlog returns the visible plus in-flight messages of the queue.
+func (c *Consumer) backlog() (int, error) {
+	visible := sqs.QueueAttributeNameApproximateNumberOfMessages
+	inFlight := sqs.QueueAttributeNameApproximateNumberOfMessagesNotVisible
+	output, err := c.svc.GetQueueAttributes(&sqs.GetQueueAttributesInput{
+		QueueUrl:       c.queueURL,
+		AttributeNames: []*string{aws.String(visible), aws.String(inFlight)},
+	})
+	if err != nil {
+		return 0, err
+	}
+	total := 0
+	for _, name := range []string{visible, inFlight} {
+		n, _ := strconv.Atoi(aws.StringValue(output.Attributes[name]))
+		metrics.Set("sqs_queue_messages", metrics.Labels{"queue": c.cfg.Name, "attribute": name}, float64(n))
+		total += n
+	}
+	return total, nil
+}
@@ -0,0 +1,39 @@
+package sqs
+
+import (
+	"testing"
+
+	"github.com/vubon/aws-examples/sqs-with-s3/config"
+)
+
+func TestScalerNext(t *testing.T) {
+	cfg := &config.Autoscale{MinWorkers: 2, MaxWorkers: 10, MessagesPerWorker: 5, ScaleDownChecks: 3}
+	// Each step is one reading, applied in order to the same scaler.
+	tests := []struct {
+		backlog, current int
+		target           int
+		resize           bool
+	}{
+		{0, 2, 2, false},
+		{11, 2, 3, true},
+		{1000, 3, 10, true},
+		{10, 10, 2, false},
+		{10, 10, 2, false},
+		{10, 10, 2, true},
+		// A reading that asks for as many workers as running resets the count.
+		{10, 2, 2, false},
+		{60, 8, 10, true},
+		{0, 10, 2, false},
+		{50, 10, 10, false},
+		{0, 10, 2, false},
+		{0, 10, 2, false},
+		{0, 10, 2, true},
+	}
+	s := &scaler{cfg: cfg}
+	for i, tt := range tests {
+		target, resize := s.next(tt.backlog, tt.current)
+		if target != tt.target || resize != tt.resize {
+			t.Errorf("reading %d: next(%d, %d) = %d, %v, want %d, %v", i, tt.backlog, tt.current, target, resize, tt.target, tt.resize)
+		}
+	}
+}
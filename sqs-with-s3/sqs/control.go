@@ -0,0 +1,99 @@
+package sqs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	pausedPollInterval = time.Second
+	drainPollInterval  = 200 * time.Millisecond
+)
+
+// ErrUnknownQueue is returned for a queue name the group does not consume.
+var ErrUnknownQueue = errors.New("unknown queue")
+
+// State is the runtime state of one consumer.
+type State struct {
+	Queue    string `json:"queue"`
+	Paused   bool   `json:"paused"`
+	Workers  int    `json:"workers"`
+	InFlight int64  `json:"inFlight"`
+	Buffered int    `json:"buffered"`
+}
+
+func (c *Consumer) State() State {
+	return State{
+		Queue:    c.cfg.Name,
+		Paused:   c.paused.Load(),
+		Workers:  c.Workers(),
+		InFlight: max(c.inflight.Load()-int64(len(c.msgs)), 0),
+		Buffered: len(c.msgs),
+	}
+}
+
+// Pause stops receiving new messages. Messages already received are still handled.
+func (c *Consumer) Pause() {
+	c.paused.Store(true)
+}
+
+func (c *Consumer) Resume() {
+	c.paused.Store(false)
+}
+
+// idle reports whether the consumer is paused with nothing left to handle.
+func (c *Consumer) idle() bool {
+	return c.paused.Load() && !c.receiving.Load() && c.inflight.Load() == 0
+}
+
+// Group controls the consumers started by SQS.
+type Group struct {
+	consumers []*Consumer
+}
+
+// Select returns the consumer of queue, or all consumers for an empty name.
+func (g *Group) Select(queue string) ([]*Consumer, error) {
+	if queue == "" {
+		return g.consumers, nil
+	}
+	for _, c := range g.consumers {
+		if c.cfg.Name == queue {
+			return []*Consumer{c}, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownQueue, queue)
+}
+
+func (g *Group) States() []State {
+	states := make([]State, len(g.consumers))
+	for i, c := range g.consumers {
+		states[i] = c.State()
+	}
+	return states
+}
+
+// Drain pauses the consumers and waits until their in-flight and buffered
+// messages are handled, or ctx is done.
+func (g *Group) Drain(ctx context.Context, consumers []*Consumer) error {
+	for _, c := range consumers {
+		c.Pause()
+	}
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for {
+		idle := true
+		for _, c := range consumers {
+			idle = idle && c.idle()
+		}
+		if idle {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
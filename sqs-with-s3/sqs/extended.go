@@ -0,0 +1,42 @@
+package sqs
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/vubon/aws-examples/sqs-with-s3/extended"
+	"github.com/vubon/aws-examples/sqs-with-s3/logging"
+	"github.com/vubon/aws-examples/sqs-with-s3/metrics"
+	"github.com/vubon/aws-examples/sqs-with-s3/s3"
+)
+
+// payload returns the body of the message, fetched from S3 for pointer
+// messages of the SQS Extended Client, along with the pointer if any.
+func (c *Consumer) payload(ctx context.Context, msg *sqs.Message) ([]byte, *extended.Pointer, error) {
+	pointer, ok := extended.Parse(msg)
+	if !ok {
+		return []byte(aws.StringValue(msg.Body)), nil, nil
+	}
+	obj, err := s3.OpenObject(ctx, c.sess, pointer.Bucket, pointer.Key, "")
+	if err != nil {
+		return nil, nil, fmt.Errorf("payload s3://%s/%s: %w", pointer.Bucket, pointer.Key, err)
+	}
+	defer obj.Body.Close()
+	body, err := io.ReadAll(obj.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("payload s3://%s/%s: %w", pointer.Bucket, pointer.Key, s3.Classify(err))
+	}
+	metrics.Inc("sqs_extended_payloads_total", c.labels)
+	return body, pointer, nil
+}
+
+// deletePayload removes the payload of an acknowledged pointer message. A
+// failure leaves the object behind but does not affect the message.
+func (c *Consumer) deletePayload(ctx context.Context, pointer *extended.Pointer) {
+	if err := s3.Delete(ctx, c.sess, pointer.Bucket, pointer.Key, ""); err != nil {
+		c.log.Error("payload delete failed", logging.Bucket, pointer.Bucket, logging.Key, pointer.Key, logging.Err(err))
+	}
+}
@@ -0,0 +1,171 @@
+package sqs
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/vubon/aws-examples/sqs-with-s3/config"
+	"github.com/vubon/aws-examples/sqs-with-s3/logging"
+	"github.com/vubon/aws-examples/sqs-with-s3/metrics"
+	"github.com/vubon/aws-examples/sqs-with-s3/ratelimit"
+	"github.com/vubon/aws-examples/sqs-with-s3/tracing"
+)
+
+// Lambda runs the consumer's pipeline for SQS events delivered to an AWS
+// Lambda function, with the routes and options of the configured queue each
+// record came from.
+type Lambda struct {
+	consumers map[string]*Consumer
+	// Local reports failures without changing visibility, dead-lettering or
+	// deleting messages, for invoking the adapter with fixture events.
+	Local bool
+
+	mu       sync.Mutex
+	resolved map[string]bool
+}
+
+// NewLambda creates the adapter for the queues in cfg. The consumers share
+// the publisher and stream set by the caller and the rate limiter built from
+// cfg; workers, autoscaling and the concurrency limit do not apply.
+func NewLambda(sess *session.Session, cfg *config.Config, shared *Shared) (*Lambda, error) {
+	shared.Limiter = ratelimit.New(cfg.RateLimits)
+	l := &Lambda{consumers: map[string]*Consumer{}, resolved: map[string]bool{}}
+	for _, q := range cfg.Queues {
+		c, err := NewConsumer(sess, q, shared)
+		if err != nil {
+			return nil, err
+		}
+		l.consumers[q.Name] = c
+	}
+	return l, nil
+}
+
+// Handle processes the records of an SQS trigger one at a time and returns
+// the records that failed as batch item failures, so that only they are
+// delivered again. The event source mapping must have ReportBatchItemFailures
+// enabled. Requeued records get the consumer's visibility backoff, and
+// dead-lettered records are moved to the queue's dead-letter queue when it
+// has one and reported as failed otherwise. On FIFO queues, the records of
+// a message group that follow a failed one are reported as failed without
+// being processed, to keep the group in order. Spans are flushed before it
+// returns, as the function may be frozen afterwards.
+func (l *Lambda) Handle(ctx context.Context, event events.SQSEvent) (events.SQSEventResponse, error) {
+	defer tracing.Flush(ctx)
+	var resp events.SQSEventResponse
+	failedGroups := map[string]bool{}
+	for _, record := range event.Records {
+		group := ""
+		if queue, _ := queueFromARN(record.EventSourceARN); strings.HasSuffix(queue, ".fifo") {
+			group = record.EventSourceARN + "/" + record.Attributes["MessageGroupId"]
+		}
+		if (group == "" || !failedGroups[group]) && l.handleRecord(ctx, record) {
+			continue
+		}
+		if group != "" {
+			failedGroups[group] = true
+		}
+		resp.BatchItemFailures = append(resp.BatchItemFailures, events.SQSBatchItemFailure{
+			ItemIdentifier: record.MessageId,
+		})
+	}
+	return resp, nil
+}
+
+// handleRecord reports whether the record can be deleted from its queue.
+func (l *Lambda) handleRecord(ctx context.Context, record events.SQSMessage) bool {
+	queue, account := queueFromARN(record.EventSourceARN)
+	c, ok := l.consumers[queue]
+	if !ok {
+		log.Error("no queue configured for record", logging.MessageID, record.MessageId, "eventSourceArn", record.EventSourceARN)
+		return false
+	}
+	msg := messageFrom(record)
+	metrics.Inc("sqs_messages_received_total", c.labels)
+	ctx, span := c.startReceive(ctx, msg)
+	pointer, err := c.Process(ctx, msg)
+	defer func() { tracing.End(span, err) }()
+	settle := !l.Local && l.resolve(c, account)
+	if err != nil {
+		return settle && c.settle(msg, err)
+	}
+	// The payload must outlive the message, so delete the message first
+	// rather than leaving it to Lambda.
+	if settle && pointer != nil && c.cfg.DeletePayloads {
+		_, deleteSpan := tracing.Start(ctx, "delete")
+		deleted := c.DeleteMessage(msg)
+		tracing.End(deleteSpan, deleted)
+		if deleted == nil {
+			c.deletePayload(ctx, pointer)
+		}
+	}
+	return true
+}
+
+// resolve looks up the queue URLs of the consumer, and reports whether
+// messages of its queue can be settled. Only successful lookups are kept, so
+// a failed one is tried again with the next record.
+func (l *Lambda) resolve(c *Consumer, account string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.resolved[c.cfg.Name] {
+		return true
+	}
+	out, err := c.svc.GetQueueUrl(&sqs.GetQueueUrlInput{
+		QueueName:              aws.String(c.cfg.Name),
+		QueueOwnerAWSAccountId: aws.String(account),
+	})
+	if err != nil {
+		c.log.Error("queue URL lookup failed", logging.Err(err))
+		return false
+	}
+	c.queueURL = out.QueueUrl
+	if c.cfg.DeadLetterQueue != "" {
+		dlqResult, err := c.GetQueueURL(c.cfg.DeadLetterQueue)
+		if err != nil {
+			// Messages are still settled, with dead-letters left for redrive.
+			c.log.Error("dead-letter queue URL lookup failed", logging.Err(err))
+			return true
+		}
+		c.dlqURL = dlqResult.QueueUrl
+	}
+	l.resolved[c.cfg.Name] = true
+	return true
+}
+
+// queueFromARN returns the queue name and owner account of an SQS queue ARN,
+// arn:aws:sqs:<region>:<account>:<name>.
+func queueFromARN(arn string) (name, account string) {
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) != 6 {
+		return "", ""
+	}
+	return parts[5], parts[4]
+}
+
+// messageFrom converts a Lambda SQS record to the message the consumer
+// receives from ReceiveMessage.
+func messageFrom(record events.SQSMessage) *sqs.Message {
+	msg := &sqs.Message{
+		MessageId:         aws.String(record.MessageId),
+		ReceiptHandle:     aws.String(record.ReceiptHandle),
+		Body:              aws.String(record.Body),
+		MD5OfBody:         aws.String(record.Md5OfBody),
+		Attributes:        aws.StringMap(record.Attributes),
+		MessageAttributes: make(map[string]*sqs.MessageAttributeValue, len(record.MessageAttributes)),
+	}
+	for name, attr := range record.MessageAttributes {
+		msg.MessageAttributes[name] = &sqs.MessageAttributeValue{
+			DataType:         aws.String(attr.DataType),
+			StringValue:      attr.StringValue,
+			BinaryValue:      attr.BinaryValue,
+			StringListValues: aws.StringSlice(attr.StringListValues),
+			BinaryListValues: attr.BinaryListValues,
+		}
+	}
+	return msg
+}
@@ -0,0 +1,185 @@
+package sqs
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/vubon/aws-examples/sqs-with-s3/config"
+	"github.com/vubon/aws-examples/sqs-with-s3/handler"
+)
+
+const payloadPath = "/payload-bucket/sqs/0f1e2d3c4b5a69788796a5b4c3d2e1f0"
+
+// recorder is a stub handler that remembers the keys it was given.
+type recorder struct {
+	mu   sync.Mutex
+	keys []string
+}
+
+func (r *recorder) Handle(_ context.Context, ev *handler.Event) (*handler.Result, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.keys = append(r.keys, ev.Key)
+	return &handler.Result{}, nil
+}
+
+func loadFixture(t *testing.T, name string) events.SQSEvent {
+	t.Helper()
+	data, err := os.ReadFile("../cmd/lambda/fixtures/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var event events.SQSEvent
+	if err := json.Unmarshal(data, &event); err != nil {
+		t.Fatal(err)
+	}
+	return event
+}
+
+// newTestLambda returns a local adapter for the "uploads" and "uploads.fifo"
+// queues whose S3 requests go to a test server holding the extended
+// fixture's payload.
+func newTestLambda(t *testing.T) (*Lambda, *recorder) {
+	t.Helper()
+	put := loadFixture(t, "put.json")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != payloadPath {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(put.Records[0].Body))
+	}))
+	t.Cleanup(srv.Close)
+	sess := session.Must(session.NewSession(&aws.Config{
+		Region:           aws.String("us-east-1"),
+		Endpoint:         aws.String(srv.URL),
+		S3ForcePathStyle: aws.Bool(true),
+		Credentials:      credentials.NewStaticCredentials("id", "secret", ""),
+	}))
+
+	rec := &recorder{}
+	handler.Register("lambda-test", rec)
+	routes := []config.Route{{Handler: "lambda-test", Events: []string{"ObjectCreated:"}}}
+	cfg := &config.Config{Queues: []config.Queue{
+		{Name: "uploads", Routes: routes},
+		{Name: "uploads.fifo", Routes: routes},
+	}}
+	l, err := NewLambda(sess, cfg, &Shared{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Local = true
+	return l, rec
+}
+
+func TestLambdaHandle(t *testing.T) {
+	tests := []struct {
+		fixture  string
+		failures []string
+		keys     []string
+	}{
+		{"put.json", nil, []string{"reports/daily.csv"}},
+		// The second record is not an S3 event and the third is from a queue
+		// that is not configured.
+		{"batch.json", []string{"00000000-0000-0000-0000-000000000002", "00000000-0000-0000-0000-000000000003"}, []string{"reports/daily.csv"}},
+		{"extended.json", nil, []string{"reports/daily.csv"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			l, rec := newTestLambda(t)
+			resp, err := l.Handle(context.Background(), loadFixture(t, tt.fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var failures []string
+			for _, f := range resp.BatchItemFailures {
+				failures = append(failures, f.ItemIdentifier)
+			}
+			if !reflect.DeepEqual(failures, tt.failures) {
+				t.Errorf("batch item failures %v, want %v", failures, tt.failures)
+			}
+			if !reflect.DeepEqual(rec.keys, tt.keys) {
+				t.Errorf("handled %v, want %v", rec.keys, tt.keys)
+			}
+		})
+	}
+}
+
+func TestLambdaHandleFIFO(t *testing.T) {
+	l, rec := newTestLambda(t)
+	put := loadFixture(t, "put.json").Records[0]
+	record := func(id, group, body string) events.SQSMessage {
+		r := put
+		r.MessageId = id
+		r.Body = body
+		r.EventSourceARN = "arn:aws:sqs:us-east-1:123456789012:uploads.fifo"
+		r.Attributes = map[string]string{"ApproximateReceiveCount": "1", "MessageGroupId": group}
+		return r
+	}
+	// The second record fails, so the fourth, of the same group, must wait
+	// for it while the third, of another group, goes ahead.
+	event := events.SQSEvent{Records: []events.SQSMessage{
+		record("1", "a", put.Body),
+		record("2", "a", "not an S3 event"),
+		record("3", "b", put.Body),
+		record("4", "a", put.Body),
+	}}
+	resp, err := l.Handle(context.Background(), event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var failures []string
+	for _, f := range resp.BatchItemFailures {
+		failures = append(failures, f.ItemIdentifier)
+	}
+	if want := []string{"2", "4"}; !reflect.DeepEqual(failures, want) {
+		t.Errorf("batch item failures %v, want %v", failures, want)
+	}
+	if want := []string{"reports/daily.csv", "reports/daily.csv"}; !reflect.DeepEqual(rec.keys, want) {
+		t.Errorf("handled %v, want %v", rec.keys, want)
+	}
+}
+
+func TestQueueFromARN(t *testing.T) {
+	tests := []struct {
+		arn, name, account string
+	}{
+		{"arn:aws:sqs:us-east-1:123456789012:uploads", "uploads", "123456789012"},
+		{"arn:aws:sqs:us-east-1:123456789012:orders.fifo", "orders.fifo", "123456789012"},
+		{"not-an-arn", "", ""},
+	}
+	for _, tt := range tests {
+		name, account := queueFromARN(tt.arn)
+		if name != tt.name || account != tt.account {
+			t.Errorf("queueFromARN(%q) = %q, %q, want %q, %q", tt.arn, name, account, tt.name, tt.account)
+		}
+	}
+}
+
+func TestMessageFrom(t *testing.T) {
+	record := loadFixture(t, "extended.json").Records[0]
+	msg := messageFrom(record)
+	if aws.StringValue(msg.MessageId) != record.MessageId || aws.StringValue(msg.ReceiptHandle) != record.ReceiptHandle {
+		t.Errorf("message id %q and receipt handle %q do not match the record", aws.StringValue(msg.MessageId), aws.StringValue(msg.ReceiptHandle))
+	}
+	if aws.StringValue(msg.Body) != record.Body {
+		t.Errorf("body %q, want %q", aws.StringValue(msg.Body), record.Body)
+	}
+	if got := aws.StringValue(msg.Attributes["ApproximateReceiveCount"]); got != "1" {
+		t.Errorf("ApproximateReceiveCount %q, want 1", got)
+	}
+	attr := msg.MessageAttributes["ExtendedPayloadSize"]
+	if attr == nil || aws.StringValue(attr.DataType) != "Number" || aws.StringValue(attr.StringValue) != "300000" {
+		t.Errorf("ExtendedPayloadSize attribute %v, want Number 300000", attr)
+	}
+}
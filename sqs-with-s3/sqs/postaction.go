@@ -0,0 +1,56 @@
+package sqs
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/vubon/aws-examples/sqs-with-s3/handler"
+	"github.com/vubon/aws-examples/sqs-with-s3/logging"
+	"github.com/vubon/aws-examples/sqs-with-s3/metrics"
+	"github.com/vubon/aws-examples/sqs-with-s3/retry"
+	"github.com/vubon/aws-examples/sqs-with-s3/s3"
+)
+
+// postActions tags and archives the source object of a handled event. Their
+// failures are published as outcomes of their own ("post:tag", "post:archive")
+// and never fail the message, whose handlers have already succeeded.
+func (c *Consumer) postActions(ev *handler.Event) {
+	pa := c.cfg.PostActions
+	if pa == nil || !strings.HasPrefix(ev.EventName, "ObjectCreated:") {
+		return
+	}
+	archiveBucket := pa.ArchiveBucket
+	if archiveBucket == "" {
+		archiveBucket = ev.Bucket
+	}
+	if pa.Archives() && ev.IsOutput(archiveBucket, pa.ArchivePrefix) {
+		return
+	}
+	if pa.Tag {
+		c.postAction("tag", ev, "", func() error {
+			return s3.TagProcessed(c.sess, ev.Bucket, ev.Key, ev.VersionID, time.Now())
+		})
+	}
+	if pa.Archives() {
+		key := pa.ArchivePrefix + ev.Key
+		c.postAction("archive", ev, fmt.Sprintf("s3://%s/%s", archiveBucket, key), func() error {
+			return s3.Archive(c.sess, ev.Bucket, ev.Key, ev.VersionID, archiveBucket, key, pa.StorageClass, pa.DeleteVersions)
+		})
+	}
+}
+
+func (c *Consumer) postAction(action string, ev *handler.Event, output string, fn func() error) {
+	started := time.Now()
+	err := fn()
+	o := newOutcome(ev, "post:"+action, started)
+	if err != nil {
+		o.Status = retry.ClassOf(err).String()
+		o.Error = err.Error()
+		c.eventLog(ev).Error("post-action failed", "action", action, logging.Err(err))
+	} else {
+		o.Output = output
+	}
+	metrics.Inc("post_action_results_total", metrics.Labels{"queue": c.cfg.Name, "action": action, "result": o.Status})
+	c.publish(o)
+}
@@ -0,0 +1,62 @@
+package sqs
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/vubon/aws-examples/sqs-with-s3/config"
+	"github.com/vubon/aws-examples/sqs-with-s3/handler"
+	"github.com/vubon/aws-examples/sqs-with-s3/outcome"
+	"github.com/vubon/aws-examples/sqs-with-s3/s3test"
+)
+
+// outcomes is a publisher that keeps "handler status" of every outcome.
+type outcomes []string
+
+func (o *outcomes) Publish(out *outcome.Outcome) error {
+	*o = append(*o, out.Handler+" "+out.Status)
+	return nil
+}
+
+func TestPostActions(t *testing.T) {
+	tests := []struct {
+		name     string
+		pa       *config.PostActions
+		event    string
+		key      string
+		ops      []string
+		outcomes []string
+	}{
+		{"tag", &config.PostActions{Tag: true}, "ObjectCreated:Put", "k",
+			[]string{"GetObjectTagging src/k", "PutObjectTagging src/k"}, []string{"post:tag success"}},
+		{"archive in place", &config.PostActions{ArchivePrefix: "archive/"}, "ObjectCreated:Put", "k",
+			[]string{"CopyObject src/archive/k", "DeleteObject src/k"}, []string{"post:archive success"}},
+		{"tag then archive", &config.PostActions{Tag: true, ArchiveBucket: "cold"}, "ObjectCreated:Put", "k",
+			[]string{"GetObjectTagging src/k", "PutObjectTagging src/k", "CopyObject cold/k", "DeleteObject src/k"},
+			[]string{"post:tag success", "post:archive success"}},
+		{"archived copy", &config.PostActions{Tag: true, ArchivePrefix: "archive/"}, "ObjectCreated:Copy", "archive/k", nil, nil},
+		{"removed", &config.PostActions{Tag: true}, "ObjectRemoved:Delete", "k", nil, nil},
+		{"missing object", &config.PostActions{Tag: true, ArchiveBucket: "cold"}, "ObjectCreated:Put", "gone",
+			[]string{"GetObjectTagging src/gone", "CopyObject cold/gone"},
+			[]string{"post:tag dead-letter", "post:archive dead-letter"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := s3test.NewServer(t)
+			srv.Put("src", "k", &s3test.Object{Body: []byte("data")})
+			srv.Put("src", "archive/k", &s3test.Object{Body: []byte("data")})
+			var published outcomes
+			c, err := NewConsumer(srv.Session(), config.Queue{Name: "uploads", PostActions: tt.pa}, &Shared{Publisher: &published})
+			if err != nil {
+				t.Fatal(err)
+			}
+			c.postActions(&handler.Event{EventName: tt.event, Bucket: "src", Key: tt.key})
+			if got := srv.Ops(); !reflect.DeepEqual(got, tt.ops) {
+				t.Errorf("operations %v, want %v", got, tt.ops)
+			}
+			if !reflect.DeepEqual([]string(published), tt.outcomes) {
+				t.Errorf("outcomes %v, want %v", published, tt.outcomes)
+			}
+		})
+	}
+}
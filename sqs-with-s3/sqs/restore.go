@@ -0,0 +1,63 @@
+package sqs
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/vubon/aws-examples/sqs-with-s3/config"
+	"github.com/vubon/aws-examples/sqs-with-s3/handler"
+	"github.com/vubon/aws-examples/sqs-with-s3/logging"
+	"github.com/vubon/aws-examples/sqs-with-s3/retry"
+)
+
+const restoreCompleted = "ObjectRestore:Completed"
+
+// resumeRestores runs the handlers of this queue that were waiting for the
+// restored object of ev, with the event they were first given. Entries are
+// completed once their handler succeeds; a failure is handled like any other
+// handler failure. The post-actions of the original event run once every
+// waiting handler has succeeded.
+func (c *Consumer) resumeRestores(ctx context.Context, ev *handler.Event) error {
+	waiting, err := c.shared.Restores.Waiting(c.cfg.Name, ev.Bucket, ev.Key, ev.VersionID)
+	if err != nil {
+		return retry.Classify(retry.Requeue, err)
+	}
+	var failed error
+	done := len(waiting) > 0
+	var original handler.Event
+	for _, e := range waiting {
+		h, ok := handler.Lookup(e.Handler)
+		if !ok {
+			// The handler was removed from the config since the restore.
+			e.Error = fmt.Sprintf("unknown handler %q", e.Handler)
+			if err := c.shared.Restores.Update(e); err != nil {
+				c.eventLog(ev).Error("restore update failed", logging.Handler, e.Handler, logging.Err(err))
+			}
+			done = false
+			continue
+		}
+		original = e.Event
+		original.MessageID = ev.MessageID
+		route := &handler.Route{Route: config.Route{Handler: e.Handler, ExpandArchives: e.ExpandArchives}, Handler: h}
+		restoring, err := c.runRoute(ctx, route, &original)
+		switch {
+		case err != nil:
+			e.Error = err.Error()
+			if uerr := c.shared.Restores.Update(e); uerr != nil {
+				c.eventLog(ev).Error("restore update failed", logging.Handler, e.Handler, logging.Err(uerr))
+			}
+		case restoring:
+			// Archived again before the handler could read it; the new
+			// request replaced the entry.
+			done = false
+		default:
+			err = c.shared.Restores.Complete(e)
+		}
+		done = done && err == nil
+		failed = worse(failed, err)
+	}
+	if done {
+		c.postActions(&original)
+	}
+	return failed
+}
@@ -1,22 +1,50 @@
 package sqs
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/vubon/aws-examples/sqs-with-s3/config"
+	"github.com/vubon/aws-examples/sqs-with-s3/extended"
+	"github.com/vubon/aws-examples/sqs-with-s3/handler"
+	"github.com/vubon/aws-examples/sqs-with-s3/health"
+	"github.com/vubon/aws-examples/sqs-with-s3/logging"
+	"github.com/vubon/aws-examples/sqs-with-s3/metrics"
+	"github.com/vubon/aws-examples/sqs-with-s3/outcome"
+	"github.com/vubon/aws-examples/sqs-with-s3/ratelimit"
+	"github.com/vubon/aws-examples/sqs-with-s3/restore"
+	"github.com/vubon/aws-examples/sqs-with-s3/retry"
 	"github.com/vubon/aws-examples/sqs-with-s3/s3"
-	"time"
+	"github.com/vubon/aws-examples/sqs-with-s3/stream"
+	"github.com/vubon/aws-examples/sqs-with-s3/tracing"
+	"go.opentelemetry.io/otel/attribute"
 )
 
-const QueueName = "<Your SQS Name>"
+var log = logging.For("sqs")
 
-var (
-	svc      *sqs.SQS
-	queueURL *string
-	sess     *session.Session
-)
+// requeueBackoff spaces out redeliveries of a requeued message by its receive count.
+var requeueBackoff = retry.Backoff{
+	Base: 5 * time.Second,
+	Max:  15 * time.Minute,
+}
+
+// receiveBackoff spaces out ReceiveMessage calls after consecutive failures.
+var receiveBackoff = retry.Backoff{
+	Base: time.Second,
+	Max:  time.Minute,
+}
 
 type Response struct {
 	Records []struct {
@@ -49,14 +77,78 @@ type Response struct {
 				Key       string `json:"key"`
 				Size      int    `json:"size"`
 				ETag      string `json:"eTag"`
+				VersionId string `json:"versionId"`
 				Sequencer string `json:"sequencer"`
 			} `json:"object"`
 		} `json:"s3"`
 	} `json:"Records"`
 }
 
-func GetQueueURL(queue string) (*sqs.GetQueueUrlOutput, error) {
-	urlResult, err := svc.GetQueueUrl(&sqs.GetQueueUrlInput{
+// Consumer polls one queue and hands its messages to a fixed number of
+// workers. All consumers of a process share the limit channel, which caps
+// the total number of messages handled at once.
+type Consumer struct {
+	cfg      config.Queue
+	sess     *session.Session
+	svc      *sqs.SQS
+	router   *handler.Router
+	shared   *Shared
+	queueURL *string
+	dlqURL   *string
+	labels   metrics.Labels
+	log      *slog.Logger
+
+	msgs    chan *sqs.Message
+	mu      sync.Mutex
+	workers int
+	quit    chan struct{}
+
+	paused    atomic.Bool
+	receiving atomic.Bool
+	inflight  atomic.Int64
+	// limitedUntil is when a rate limit that handed a message back has
+	// tokens again, in Unix nanoseconds; receiving waits for it.
+	limitedUntil atomic.Int64
+}
+
+// Shared holds what all consumers of a process have in common.
+type Shared struct {
+	// Limit caps the number of messages handled at once across all queues.
+	Limit     chan struct{}
+	Limiter   *ratelimit.Limiter
+	Publisher outcome.Publisher
+	// Stream receives every event before it is handled.
+	Stream *stream.Hub
+	// Restores, when set, restores archived objects instead of failing
+	// their handlers, and resumes them on ObjectRestore:Completed events.
+	Restores *restore.Store
+}
+
+// NewConsumer creates a consumer for one configured queue.
+func NewConsumer(sess *session.Session, cfg config.Queue, shared *Shared) (*Consumer, error) {
+	router, err := handler.NewRouter(cfg.Routes)
+	if err != nil {
+		return nil, fmt.Errorf("queue %s: %w", cfg.Name, err)
+	}
+	return &Consumer{
+		cfg:    cfg,
+		sess:   sess,
+		svc:    sqs.New(sess),
+		router: router,
+		shared: shared,
+		labels: metrics.Labels{"queue": cfg.Name},
+		log:    log.With(logging.Queue, cfg.Name),
+		msgs:   make(chan *sqs.Message, batchSize(cfg.Workers)),
+		quit:   make(chan struct{}),
+	}, nil
+}
+
+func (c *Consumer) component() string {
+	return receiverComponent + ":" + c.cfg.Name
+}
+
+func (c *Consumer) GetQueueURL(queue string) (*sqs.GetQueueUrlOutput, error) {
+	urlResult, err := c.svc.GetQueueUrl(&sqs.GetQueueUrlInput{
 		QueueName: &queue,
 	})
 	// snippet-end:[sqs.go.receive_messages.queue_url]
@@ -67,88 +159,518 @@ func GetQueueURL(queue string) (*sqs.GetQueueUrlOutput, error) {
 	return urlResult, nil
 }
 
-func pullMessages(chn chan<- *sqs.Message) {
+func (c *Consumer) pullMessages(chn chan<- *sqs.Message) {
+	failures := 0
 	for {
-		output, err := svc.ReceiveMessage(&sqs.ReceiveMessageInput{
+		if c.paused.Load() {
+			time.Sleep(pausedPollInterval)
+			continue
+		}
+		// Every receive counts towards the redrive policy's maxReceiveCount,
+		// so stop receiving while messages are being handed back unhandled.
+		if wait := time.Until(time.Unix(0, c.limitedUntil.Load())); wait > 0 {
+			time.Sleep(wait)
+			continue
+		}
+		// Announce the receive before the last look at paused, so that idle
+		// either sees it or the receive sees the pause.
+		c.receiving.Store(true)
+		if c.paused.Load() {
+			c.receiving.Store(false)
+			continue
+		}
+		output, err := c.svc.ReceiveMessage(&sqs.ReceiveMessageInput{
 			AttributeNames: []*string{
 				aws.String(sqs.MessageSystemAttributeNameSentTimestamp),
+				aws.String(sqs.MessageSystemAttributeNameApproximateReceiveCount),
+				aws.String(sqs.MessageSystemAttributeNameAwstraceHeader),
 			},
 			MessageAttributeNames: []*string{
 				aws.String(sqs.QueueAttributeNameAll),
 			},
-			QueueUrl:            queueURL,
-			MaxNumberOfMessages: aws.Int64(2),
+			QueueUrl:            c.queueURL,
+			MaxNumberOfMessages: aws.Int64(int64(batchSize(c.Workers()))),
 			WaitTimeSeconds:     aws.Int64(15),
 		})
 
 		if err != nil {
-			fmt.Printf("failed to fetch sqs message %v\n", err)
+			c.receiving.Store(false)
+			delay := receiveBackoff.Delay(failures)
+			failures++
+			c.log.Error("receive failed", logging.Err(err), "retryIn", delay)
+			metrics.Inc("sqs_receive_errors_total", c.labels)
+			if isFatalReceiveError(err) {
+				health.Set(c.component(), err)
+			}
+			time.Sleep(delay)
+			continue
 		}
+		failures = 0
+		health.Set(c.component(), nil)
 
+		metrics.Add("sqs_messages_received_total", c.labels, float64(len(output.Messages)))
+		// Messages count as in flight from here, so a paused consumer is never
+		// idle while a worker is between taking a message and handling it.
+		c.inflight.Add(int64(len(output.Messages)))
 		for _, message := range output.Messages {
 			chn <- message
 		}
+		c.receiving.Store(false)
 
 	}
 }
 
-func DeleteMessage(msg *sqs.Message) {
-	_, err := svc.DeleteMessage(&sqs.DeleteMessageInput{
-		QueueUrl:      queueURL,
+// batchSize is how many messages to ask for at once, between 1 and the SQS maximum of 10.
+func batchSize(workers int) int {
+	return min(max(workers, 1), 10)
+}
+
+func (c *Consumer) DeleteMessage(msg *sqs.Message) error {
+	_, err := c.svc.DeleteMessage(&sqs.DeleteMessageInput{
+		QueueUrl:      c.queueURL,
 		ReceiptHandle: msg.ReceiptHandle,
 	})
 	if err != nil {
-		fmt.Println("Delete error", err)
+		c.messageLog(msg).Error("delete failed", logging.Err(err))
+		return err
 	}
-	fmt.Println("Delete Queue message: ", msg.MessageId)
+	c.messageLog(msg).Debug("deleted message")
+	return nil
 }
 
-func MessageHandler(msg *sqs.Message) {
-	fmt.Println("RECEIVING MESSAGE >>> ")
-	//fmt.Println(*msg.Body)
-	var resp Response
-	errJSON := json.Unmarshal([]byte(*msg.Body), &resp)
-	if errJSON != nil {
-		fmt.Println("JSON Unmarshal error", errJSON)
+// ReturnMessage makes the message visible again after a delay that grows
+// with the number of times it has been received.
+func (c *Consumer) ReturnMessage(msg *sqs.Message) {
+	receiveCount, _ := strconv.Atoi(aws.StringValue(msg.Attributes[sqs.MessageSystemAttributeNameApproximateReceiveCount]))
+	c.DelayMessage(msg, requeueBackoff.Delay(receiveCount))
+}
+
+// DelayMessage makes the message visible again after delay, rounded up to
+// whole seconds.
+func (c *Consumer) DelayMessage(msg *sqs.Message, delay time.Duration) {
+	seconds := int64((delay + time.Second - 1) / time.Second)
+	_, err := c.svc.ChangeMessageVisibility(&sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          c.queueURL,
+		ReceiptHandle:     msg.ReceiptHandle,
+		VisibilityTimeout: aws.Int64(seconds),
+	})
+	if err != nil {
+		c.messageLog(msg).Error("change visibility failed", logging.Err(err))
+		return
+	}
+	c.messageLog(msg).Info("returned message", "visibleIn", time.Duration(seconds)*time.Second)
+}
+
+// maxMessageAttributes is the number of message attributes SQS accepts.
+const maxMessageAttributes = 10
+
+// DeadLetterMessage sends the message to the dead-letter queue and deletes it
+// from the source queue. Without a dead-letter queue the message is left for
+// the queue's redrive policy. It reports whether the message was moved.
+// The message keeps its attributes and gets a DeadLetterReason, unless it
+// already has the ten attributes SQS allows.
+func (c *Consumer) DeadLetterMessage(msg *sqs.Message, cause error) bool {
+	if c.dlqURL == nil {
+		c.messageLog(msg).Warn("no dead-letter queue configured, leaving message for redrive", "reason", cause)
+		return false
 	}
-	bucketName := resp.Records[0].S3.Bucket.Name
-	fileName := resp.Records[0].S3.Object.Key
-	fmt.Println("Bucket name:  ", bucketName, "File Name: ", fileName)
-	err := s3.DownloadObject(sess, fileName, bucketName)
+	attrs := make(map[string]*sqs.MessageAttributeValue, len(msg.MessageAttributes)+1)
+	for name, v := range msg.MessageAttributes {
+		// List values are reserved by SQS and rejected on send.
+		attrs[name] = &sqs.MessageAttributeValue{DataType: v.DataType, StringValue: v.StringValue, BinaryValue: v.BinaryValue}
+	}
+	if len(attrs) < maxMessageAttributes {
+		attrs["DeadLetterReason"] = &sqs.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(cause.Error()),
+		}
+	}
+	_, err := c.svc.SendMessage(&sqs.SendMessageInput{
+		QueueUrl:          c.dlqURL,
+		MessageBody:       msg.Body,
+		MessageAttributes: attrs,
+	})
 	if err != nil {
-		fmt.Println("Got error ", err)
+		c.messageLog(msg).Error("dead-letter send failed", logging.Err(err))
+		return false
+	}
+	c.messageLog(msg).Warn("dead-lettered message", "reason", cause)
+	return c.DeleteMessage(msg) == nil
+}
+
+// Events normalises the records of a decoded message body.
+func (c *Consumer) Events(msg *sqs.Message, resp *Response) []*handler.Event {
+	events := make([]*handler.Event, 0, len(resp.Records))
+	for _, record := range resp.Records {
+		// Keys in S3 notifications are URL encoded, with spaces as '+'.
+		key, err := url.QueryUnescape(record.S3.Object.Key)
+		if err != nil {
+			key = record.S3.Object.Key
+		}
+		events = append(events, &handler.Event{
+			MessageID: aws.StringValue(msg.MessageId),
+			Queue:     c.cfg.Name,
+			EventName: record.EventName,
+			EventTime: record.EventTime,
+			Region:    record.AwsRegion,
+			Bucket:    record.S3.Bucket.Name,
+			Key:       key,
+			Size:      int64(record.S3.Object.Size),
+			ETag:      record.S3.Object.ETag,
+			VersionID: record.S3.Object.VersionId,
+			Sequencer: record.S3.Object.Sequencer,
+		})
+	}
+	return events
+}
+
+// messageLog returns the consumer's logger with the message's ID.
+func (c *Consumer) messageLog(msg *sqs.Message) *slog.Logger {
+	return c.log.With(logging.MessageID, aws.StringValue(msg.MessageId))
+}
+
+// eventLog returns the consumer's logger with the fields of the event.
+func (c *Consumer) eventLog(ev *handler.Event) *slog.Logger {
+	attrs := []any{logging.MessageID, ev.MessageID, logging.Bucket, ev.Bucket, logging.Key, ev.Key, logging.EventName, ev.EventName}
+	if ev.VersionID != "" {
+		attrs = append(attrs, logging.VersionID, ev.VersionID)
+	}
+	if ev.Entry != "" {
+		attrs = append(attrs, logging.Entry, ev.Entry)
+	}
+	return c.log.With(attrs...)
+}
+
+// MessageHandler handles a message and then acknowledges, requeues or
+// dead-letters it.
+func (c *Consumer) MessageHandler(msg *sqs.Message) {
+	c.messageLog(msg).Debug("received message")
+	ctx, span := c.startReceive(context.Background(), msg)
+	pointer, err := c.Process(ctx, msg)
+	defer func() { tracing.End(span, err) }()
+	if err != nil {
+		c.settle(msg, err)
+		return
 	}
 	// If everything is okay, then delete the Queue message.
-	DeleteMessage(msg)
+	_, deleteSpan := tracing.Start(ctx, "delete")
+	deleted := c.DeleteMessage(msg)
+	tracing.End(deleteSpan, deleted)
+	if deleted == nil && pointer != nil && c.cfg.DeletePayloads {
+		c.deletePayload(ctx, pointer)
+	}
 }
 
-func SQS() {
-	// Create a session that gets credential values from ~/.aws/credentials
-	// and the default region from ~/.aws/config
-	sess = session.Must(session.NewSessionWithOptions(session.Options{
-		SharedConfigState: session.SharedConfigEnable,
-	}))
-	// Create an SQS service client
-	svc = sqs.New(sess)
+// rateLimited is returned by Process when the message would exceed a rate
+// limit and should be handed back after wait.
+type rateLimited struct {
+	wait time.Duration
+}
 
-	// Get URL of queue
-	urlResult, err := GetQueueURL(QueueName)
+func (e *rateLimited) Error() string {
+	return fmt.Sprintf("rate limited for %v", e.wait)
+}
+
+// decode fetches the payload of the message and unmarshals the S3 event.
+func (c *Consumer) decode(ctx context.Context, msg *sqs.Message) (resp *Response, pointer *extended.Pointer, err error) {
+	ctx, span := tracing.Start(ctx, "decode")
+	defer func() { tracing.End(span, err) }()
+	body, pointer, err := c.payload(ctx, msg)
 	if err != nil {
-		fmt.Println("Got an error getting the queue URL:", err)
+		c.messageLog(msg).Error("payload fetch failed", logging.Err(err))
+		return nil, pointer, err
+	}
+	span.SetAttributes(attribute.Bool("extended", pointer != nil), attribute.Int("bodySize", len(body)))
+	resp = &Response{}
+	errJSON := json.Unmarshal(body, resp)
+	if errJSON != nil {
+		c.messageLog(msg).Error("message is not an S3 event", logging.Err(errJSON))
+		return nil, pointer, retry.Classify(retry.DeadLetter, errJSON)
 	}
-	queueURL = urlResult.QueueUrl
+	return resp, pointer, nil
+}
 
-	chnMessages := make(chan *sqs.Message, 2)
-	go func() {
-		defer func() {
-			if err := recover(); err != nil {
-				fmt.Println("Recover from second layer of concurrency", err)
-			}
-		}()
-		pullMessages(chnMessages)
-	}()
+// Process runs the handlers of every event in the message, and then the
+// post-actions when all of them succeeded. It leaves the message on the
+// queue; the returned error is classified with the retry package. The pointer
+// is set for extended client messages.
+func (c *Consumer) Process(ctx context.Context, msg *sqs.Message) (*extended.Pointer, error) {
+	resp, pointer, err := c.decode(ctx, msg)
+	if err != nil {
+		return pointer, err
+	}
 
-	for message := range chnMessages {
-		MessageHandler(message)
+	_, enrichSpan := tracing.Start(ctx, "enrich")
+	events := c.Events(msg, resp)
+	routes := make([][]*handler.Route, len(events))
+	var uses []ratelimit.Use
+	for i, ev := range events {
+		routes[i] = c.router.Routes(ev)
+		for _, route := range routes[i] {
+			uses = append(uses, ratelimit.Use{Bucket: ev.Bucket, Handler: route.Route.Handler})
+		}
+	}
+	enrichSpan.SetAttributes(attribute.Int("events", len(events)), attribute.Int("routes", len(uses)))
+	// Over the limit, hand the message back instead of holding the worker.
+	if ok, wait := c.shared.Limiter.Allow(uses); !ok {
+		c.messageLog(msg).Info("rate limited message", "wait", wait)
+		err := retry.Classify(retry.Requeue, &rateLimited{wait: wait})
+		tracing.End(enrichSpan, err)
+		return pointer, err
+	}
+	enrichSpan.End()
+
+	var failed error
+	restoring := make([]bool, len(events))
+	for i, ev := range events {
+		c.eventLog(ev).Info("handling event", "routes", len(routes[i]))
+		c.shared.Stream.PublishEvent(ev)
+		if c.shared.Restores != nil && strings.HasPrefix(ev.EventName, restoreCompleted) {
+			failed = worse(failed, c.resumeRestores(ctx, ev))
+		}
+		for _, route := range routes[i] {
+			r, err := c.runRoute(ctx, route, ev)
+			restoring[i] = restoring[i] || r
+			failed = worse(failed, err)
+		}
+	}
+	if failed != nil {
+		return pointer, failed
+	}
+	// Post-actions run once every handler of the message has succeeded, so a
+	// moved object is never handled again on redelivery. Objects being
+	// restored get them once their handlers have resumed.
+	for i, ev := range events {
+		if len(routes[i]) > 0 && !restoring[i] {
+			c.postActions(ev)
+		}
+	}
+	return pointer, nil
+}
+
+// settle hands a failed message back, delayed when it was rate limited, or
+// dead-letters it. A rate limited message also holds off the next receive
+// until the limit has tokens again. It reports whether the message left the
+// queue.
+func (c *Consumer) settle(msg *sqs.Message, err error) bool {
+	var limited *rateLimited
+	switch {
+	case errors.As(err, &limited):
+		c.limitedUntil.Store(time.Now().Add(limited.wait).UnixNano())
+		c.DelayMessage(msg, limited.wait)
+	case retry.ClassOf(err) == retry.DeadLetter:
+		return c.DeadLetterMessage(msg, err)
+	default:
+		c.ReturnMessage(msg)
+	}
+	return false
+}
+
+// worse returns the error that decides what happens to the message: anything
+// worth retrying wins over dead-lettering.
+func worse(failed, err error) error {
+	if err != nil && (failed == nil || retry.ClassOf(failed) == retry.DeadLetter) {
+		return err
+	}
+	return failed
+}
+
+// runRoute runs the route's handler for the event, or for every file of an
+// archive when the route expands archives, and publishes one outcome per run.
+// Expansion stops at the first failing file.
+// It reports whether the object turned out to be archived and is being
+// restored instead.
+func (c *Consumer) runRoute(ctx context.Context, route *handler.Route, ev *handler.Event) (bool, error) {
+	if !route.Route.ExpandArchives || !strings.HasPrefix(ev.EventName, "ObjectCreated:") || !s3.IsArchive(ev.Key) {
+		return c.runHandler(ctx, route, ev)
+	}
+	started := time.Now()
+	opened := false
+	err := s3.WalkArchive(ctx, c.sess, ev.Bucket, ev.Key, ev.VersionID, ev.Size, ev.ETag, func(e *s3.Entry) error {
+		opened = true
+		_, err := c.runHandler(ctx, route, ev.ForEntry(e))
+		return err
+	})
+	if errors.Is(err, s3.ErrNotArchive) {
+		return c.runHandler(ctx, route, ev)
+	}
+	if opened {
+		return false, err
+	}
+	// The archive itself could not be read, e.g. because it is archived.
+	restoring, err := c.restoreArchived(ctx, route, ev, err)
+	if restoring {
+		o := newOutcome(ev, route.Route.Handler, started)
+		o.Status = restore.StatusRequested
+		metrics.Inc("sqs_handler_results_total", metrics.Labels{"queue": c.cfg.Name, "handler": route.Route.Handler, "result": o.Status})
+		c.publish(o)
+	}
+	return restoring, err
+}
+
+// restoreArchived requests a restore of the object of ev when err says it is
+// archived, so the route runs again once the object is readable. It reports
+// whether it did, along with the error that is left.
+func (c *Consumer) restoreArchived(ctx context.Context, route *handler.Route, ev *handler.Event, err error) (bool, error) {
+	if err == nil || c.shared.Restores == nil || ev.Entry != "" || !s3.IsArchived(err) {
+		return false, err
+	}
+	if rerr := c.shared.Restores.Request(ctx, c.cfg.Name, route.Route, ev); rerr != nil {
+		return false, rerr
+	}
+	return true, nil
+}
+
+func (c *Consumer) runHandler(ctx context.Context, route *handler.Route, ev *handler.Event) (restoring bool, err error) {
+	ctx, span := tracing.Start(ctx, "handler", handlerAttributes(route.Route.Handler, ev)...)
+	defer func() { tracing.End(span, err) }()
+	started := time.Now()
+	res, err := route.Handler.Handle(ctx, ev)
+	restoring, err = c.restoreArchived(ctx, route, ev, err)
+	o := newOutcome(ev, route.Route.Handler, started)
+	if restoring {
+		o.Status = restore.StatusRequested
+	}
+	if res != nil {
+		o.Output = res.Output
+		o.Details = res.Details
+		if res.Status != "" && err == nil {
+			o.Status = res.Status
+		}
+	}
+	if err != nil {
+		o.Status = retry.ClassOf(err).String()
+		o.Error = err.Error()
+		c.eventLog(ev).Error("handler failed", logging.Handler, route.Route.Handler, logging.Err(err), "action", retry.ClassOf(err).String())
+	}
+	metrics.Inc("sqs_handler_results_total", metrics.Labels{"queue": c.cfg.Name, "handler": route.Route.Handler, "result": o.Status})
+	c.publish(o)
+	return restoring, err
+}
+
+func newOutcome(ev *handler.Event, handlerName string, started time.Time) *outcome.Outcome {
+	return &outcome.Outcome{
+		MessageID:  ev.MessageID,
+		Queue:      ev.Queue,
+		EventName:  ev.EventName,
+		EventTime:  ev.EventTime,
+		Bucket:     ev.Bucket,
+		Key:        ev.Key,
+		Entry:      ev.Entry,
+		VersionID:  ev.VersionID,
+		Handler:    handlerName,
+		Status:     outcome.StatusSuccess,
+		DurationMs: time.Since(started).Milliseconds(),
+		Time:       time.Now(),
+	}
+}
+
+// publish reports an outcome. Failing to publish never fails the message.
+func (c *Consumer) publish(o *outcome.Outcome) {
+	if c.shared.Publisher == nil {
+		return
+	}
+	if err := c.shared.Publisher.Publish(o); err != nil {
+		c.log.Error("outcome publish failed", logging.MessageID, o.MessageID, logging.Handler, o.Handler, logging.Err(err))
+		metrics.Inc("outcome_publish_errors_total", c.labels)
+	}
+}
+
+// Run resolves the queue URLs and consumes the queue until the process exits.
+func (c *Consumer) Run() {
+	// Get URL of queue, waiting for credentials or the queue to become available.
+	for failures := 0; ; failures++ {
+		urlResult, err := c.GetQueueURL(c.cfg.Name)
+		if err == nil {
+			c.queueURL = urlResult.QueueUrl
+			health.Set(c.component(), nil)
+			break
+		}
+		c.log.Error("queue URL lookup failed", logging.Err(err))
+		health.Set(c.component(), err)
+		time.Sleep(receiveBackoff.Delay(failures))
+	}
+
+	if c.cfg.DeadLetterQueue != "" {
+		dlqResult, err := c.GetQueueURL(c.cfg.DeadLetterQueue)
+		if err != nil {
+			c.log.Error("dead-letter queue URL lookup failed", logging.Err(err))
+		} else {
+			c.dlqURL = dlqResult.QueueUrl
+		}
+	}
+
+	// Start the workers first so received messages are handled straight away.
+	c.SetWorkers(c.cfg.Workers)
+	go Supervise(c.component(), func() {
+		c.pullMessages(c.msgs)
+	})
+
+	if c.cfg.Autoscale != nil {
+		go c.autoscale()
+	}
+	select {}
+}
+
+// Workers returns the current size of the worker pool.
+func (c *Consumer) Workers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.workers
+}
+
+// SetWorkers grows or shrinks the worker pool to n. Shrinking lets busy
+// workers finish their current message before they exit.
+func (c *Consumer) SetWorkers(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for ; c.workers < n; c.workers++ {
+		go c.worker()
+	}
+	for ; c.workers > n; c.workers-- {
+		go func() { c.quit <- struct{}{} }()
+	}
+	metrics.Set("sqs_workers_current", c.labels, float64(c.workers))
+}
+
+func (c *Consumer) worker() {
+	for {
+		var message *sqs.Message
+		select {
+		case <-c.quit:
+			return
+		case message = <-c.msgs:
+		}
+		c.shared.Limit <- struct{}{}
+		metrics.Gauge("sqs_messages_inflight", c.labels, 1)
+		// A panicking handler leaves the message to reappear after its visibility timeout.
+		if err := runRecovered(func() { c.MessageHandler(message) }); err != errExited {
+			c.messageLog(message).Error("message handler failed", logging.Err(err))
+		}
+		metrics.Gauge("sqs_messages_inflight", c.labels, -1)
+		<-c.shared.Limit
+		c.inflight.Add(-1)
+	}
+}
+
+// SQS starts a consumer for every configured queue. The consumers share the
+// publisher and stream set by the caller, the concurrency limit and rate
+// limiter built here from cfg, and the process-wide health and metrics.
+func SQS(sess *session.Session, cfg *config.Config, shared *Shared) (*Group, error) {
+	shared.Limit = make(chan struct{}, cfg.MaxConcurrency)
+	shared.Limiter = ratelimit.New(cfg.RateLimits)
+	consumers := make([]*Consumer, 0, len(cfg.Queues))
+	for _, q := range cfg.Queues {
+		c, err := NewConsumer(sess, q, shared)
+		if err != nil {
+			return nil, err
+		}
+		consumers = append(consumers, c)
+	}
+	for _, c := range consumers {
+		go c.Run()
 	}
+	group := &Group{consumers: consumers}
+	health.SetInfo("consumers", func() interface{} { return group.States() })
+	return group, nil
 }
@@ -0,0 +1,56 @@
+package sqs
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/vubon/aws-examples/sqs-with-s3/health"
+	"github.com/vubon/aws-examples/sqs-with-s3/logging"
+)
+
+const receiverComponent = "sqs-receiver"
+
+var errExited = errors.New("exited")
+
+// fatalReceiveCodes are errors that will not clear up on their own and need
+// an operator: missing or invalid credentials and a missing queue.
+var fatalReceiveCodes = map[string]bool{
+	"NoCredentialProviders":       true,
+	"ExpiredToken":                true,
+	"ExpiredTokenException":       true,
+	"InvalidClientTokenId":        true,
+	"UnrecognizedClientException": true,
+	"AccessDenied":                true,
+	"AccessDeniedException":       true,
+	sqs.ErrCodeQueueDoesNotExist:  true,
+}
+
+func isFatalReceiveError(err error) bool {
+	var aerr awserr.Error
+	return errors.As(err, &aerr) && fatalReceiveCodes[aerr.Code()]
+}
+
+// Supervise runs fn and restarts it with backoff whenever it panics or
+// returns, reporting each crash to the health status under name.
+func Supervise(name string, fn func()) {
+	for restarts := 0; ; restarts++ {
+		err := runRecovered(fn)
+		health.Set(name, err)
+		delay := receiveBackoff.Delay(restarts)
+		log.Error("supervisor restarting", "name", name, "in", delay, logging.Err(err))
+		time.Sleep(delay)
+	}
+}
+
+func runRecovered(fn func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	fn()
+	return errExited
+}
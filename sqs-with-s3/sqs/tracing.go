@@ -0,0 +1,72 @@
+package sqs
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/vubon/aws-examples/sqs-with-s3/handler"
+	"github.com/vubon/aws-examples/sqs-with-s3/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// messageCarrier reads the trace context of a message: the X-Ray header from
+// the AWSTraceHeader system attribute, and other fields such as traceparent
+// from string message attributes of the same name.
+type messageCarrier struct {
+	msg *sqs.Message
+}
+
+func (m messageCarrier) Get(key string) string {
+	if key == tracing.XRayHeader {
+		if header := aws.StringValue(m.msg.Attributes[sqs.MessageSystemAttributeNameAwstraceHeader]); header != "" {
+			return header
+		}
+	}
+	if attr := m.msg.MessageAttributes[key]; attr != nil {
+		return aws.StringValue(attr.StringValue)
+	}
+	return ""
+}
+
+// Set is a no-op; trace context is only read from messages.
+func (m messageCarrier) Set(string, string) {}
+
+func (m messageCarrier) Keys() []string {
+	keys := make([]string, 0, len(m.msg.MessageAttributes))
+	for key := range m.msg.MessageAttributes {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// startReceive starts the span that every other span of the message
+// descends from, continuing the sender's trace when the message carries one.
+func (c *Consumer) startReceive(ctx context.Context, msg *sqs.Message) (context.Context, trace.Span) {
+	ctx = tracing.Extract(ctx, messageCarrier{msg})
+	return tracing.Tracer().Start(ctx, "receive",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			semconv.MessagingSystem("aws_sqs"),
+			semconv.MessagingDestinationName(c.cfg.Name),
+			semconv.MessagingMessageID(aws.StringValue(msg.MessageId)),
+		))
+}
+
+func handlerAttributes(name string, ev *handler.Event) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		attribute.String("handler", name),
+		attribute.String("s3.event", ev.EventName),
+		attribute.String("s3.bucket", ev.Bucket),
+		attribute.String("s3.key", ev.Key),
+	}
+	if ev.VersionID != "" {
+		attrs = append(attrs, attribute.String("s3.version_id", ev.VersionID))
+	}
+	if ev.Entry != "" {
+		attrs = append(attrs, attribute.String("s3.entry", ev.Entry))
+	}
+	return attrs
+}
@@ -0,0 +1,175 @@
+package stream
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vubon/aws-examples/sqs-with-s3/handler"
+	"github.com/vubon/aws-examples/sqs-with-s3/metrics"
+	"github.com/vubon/aws-examples/sqs-with-s3/outcome"
+)
+
+const (
+	// clientBuffer is how many messages a client may fall behind before it is dropped.
+	clientBuffer      = 64
+	heartbeatInterval = 15 * time.Second
+)
+
+// message is one server-sent event, kept with the fields filters look at.
+type message struct {
+	id        uint64
+	kind      string
+	data      []byte
+	bucket    string
+	key       string
+	eventName string
+}
+
+// filter selects messages by bucket, key prefix and event name prefix.
+// Empty fields match everything.
+type filter struct {
+	buckets []string
+	prefix  string
+	events  []string
+}
+
+func (f *filter) match(m *message) bool {
+	if !strings.HasPrefix(m.key, f.prefix) {
+		return false
+	}
+	if len(f.buckets) > 0 && !contains(f.buckets, m.bucket) {
+		return false
+	}
+	if len(f.events) == 0 {
+		return true
+	}
+	for _, e := range f.events {
+		if strings.HasPrefix(m.eventName, e) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+type client struct {
+	filter filter
+	ch     chan *message
+}
+
+// Hub fans S3 events and their outcomes out to Server-Sent Events clients.
+// Clients that cannot keep up are dropped so workers never block on them.
+type Hub struct {
+	mu      sync.Mutex
+	clients map[*client]bool
+	lastID  uint64
+}
+
+func NewHub() *Hub {
+	return &Hub{clients: map[*client]bool{}}
+}
+
+// PublishEvent streams a normalised S3 event as it is received.
+func (h *Hub) PublishEvent(ev *handler.Event) {
+	if h == nil {
+		return
+	}
+	h.broadcast("s3event", ev, ev.Bucket, ev.Key, ev.EventName)
+}
+
+// Publish streams a handler outcome. It implements outcome.Publisher.
+func (h *Hub) Publish(o *outcome.Outcome) error {
+	h.broadcast("outcome", o, o.Bucket, o.Key, o.EventName)
+	return nil
+}
+
+func (h *Hub) broadcast(kind string, v interface{}, bucket, key, eventName string) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastID++
+	m := &message{id: h.lastID, kind: kind, data: data, bucket: bucket, key: key, eventName: eventName}
+	for c := range h.clients {
+		if !c.filter.match(m) {
+			continue
+		}
+		select {
+		case c.ch <- m:
+		default:
+			h.drop(c)
+			metrics.Inc("stream_clients_dropped_total", nil)
+		}
+	}
+}
+
+// drop removes a client and closes its channel. The caller holds h.mu.
+func (h *Hub) drop(c *client) {
+	if h.clients[c] {
+		delete(h.clients, c)
+		close(c.ch)
+		metrics.Set("stream_clients", nil, float64(len(h.clients)))
+	}
+}
+
+// ServeHTTP streams messages to one client until it disconnects or is dropped.
+// Query parameters bucket and event may repeat; prefix filters keys.
+func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	query := r.URL.Query()
+	c := &client{
+		filter: filter{buckets: query["bucket"], prefix: query.Get("prefix"), events: query["event"]},
+		ch:     make(chan *message, clientBuffer),
+	}
+	h.mu.Lock()
+	h.clients[c] = true
+	metrics.Set("stream_clients", nil, float64(len(h.clients)))
+	h.mu.Unlock()
+	defer func() {
+		h.mu.Lock()
+		h.drop(c)
+		h.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case m, ok := <-c.ch:
+			if !ok {
+				return
+			}
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", m.id, m.kind, m.data)
+			flusher.Flush()
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package tracing
+
+import (
+	"context"
+	"net"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/vubon/aws-examples/sqs-with-s3/config"
+	"github.com/vubon/aws-examples/sqs-with-s3/logging"
+	"go.opentelemetry.io/contrib/propagators/aws/xray"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// XRayHeader is the carrier key of X-Ray trace headers, which SQS passes on
+// as the AWSTraceHeader system attribute.
+const XRayHeader = "X-Amzn-Trace-Id"
+
+const dialTimeout = 2 * time.Second
+
+var (
+	provider *sdktrace.TracerProvider
+	log      = logging.For("tracing")
+)
+
+func init() {
+	// X-Ray first, so a W3C traceparent wins when a message carries both.
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		xray.Propagator{}, propagation.TraceContext{}, propagation.Baggage{}))
+}
+
+// Setup installs the tracer provider described by cfg. Without cfg, spans
+// are not recorded, but trace context is still propagated. The returned
+// function flushes and stops the exporter.
+func Setup(ctx context.Context, cfg *config.Tracing) (func(context.Context) error, error) {
+	if cfg == nil {
+		return func(context.Context) error { return nil }, nil
+	}
+	exporter, err := newExporter(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	provider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(cfg.ServiceName))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+func newExporter(ctx context.Context, cfg *config.Tracing) (sdktrace.SpanExporter, error) {
+	if cfg.Exporter == "otlp" {
+		conn, err := net.DialTimeout("tcp", cfg.Endpoint, dialTimeout)
+		if err == nil {
+			conn.Close()
+			opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(cfg.Endpoint)}
+			if cfg.Insecure {
+				opts = append(opts, otlptracehttp.WithInsecure())
+			}
+			return otlptracehttp.New(ctx, opts...)
+		}
+		log.Warn("trace collector unreachable, writing spans to stderr", logging.Err(err))
+	}
+	// Spans go to stderr so they do not mix with the log lines on stdout.
+	return stdouttrace.New(stdouttrace.WithWriter(os.Stderr))
+}
+
+// Flush exports the spans ended so far, e.g. before a Lambda invocation
+// returns.
+func Flush(ctx context.Context) {
+	if provider == nil {
+		return
+	}
+	if err := provider.ForceFlush(ctx); err != nil {
+		log.Error("flush failed", logging.Err(err))
+	}
+}
+
+// Tracer returns the consumer's tracer.
+func Tracer() trace.Tracer {
+	return otel.Tracer("github.com/vubon/aws-examples/sqs-with-s3")
+}
+
+// Start starts a span named name as a child of the span in ctx.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End records err, if any, on the span and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Extract returns ctx with the remote span context found in carrier.
+func Extract(ctx context.Context, carrier propagation.TextMapCarrier) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, carrier)
+}
+
+// requestSpan is the context key of the span Instrument starts for a request,
+// so that it never ends a span it did not start.
+type requestSpan struct{}
+
+// Instrument adds a client span to every AWS request made through sess with
+// a context that is already part of a trace, such as the GetObject calls of
+// a handler. The span covers all retries of the request.
+func Instrument(sess *session.Session) {
+	sess.Handlers.Build.PushFrontNamed(request.NamedHandler{
+		Name: "tracing.Start",
+		Fn: func(r *request.Request) {
+			ctx := r.Context()
+			if !trace.SpanContextFromContext(ctx).IsValid() {
+				return
+			}
+			ctx, span := Tracer().Start(ctx, r.ClientInfo.ServiceID+"."+r.Operation.Name,
+				trace.WithSpanKind(trace.SpanKindClient),
+				trace.WithAttributes(
+					semconv.RPCSystemKey.String("aws-api"),
+					semconv.RPCService(r.ClientInfo.ServiceID),
+					semconv.RPCMethod(r.Operation.Name),
+				))
+			r.SetContext(context.WithValue(ctx, requestSpan{}, span))
+		},
+	})
+	sess.Handlers.Complete.PushBackNamed(request.NamedHandler{
+		Name: "tracing.End",
+		Fn: func(r *request.Request) {
+			span, ok := r.Context().Value(requestSpan{}).(trace.Span)
+			if !ok {
+				return
+			}
+			if r.HTTPResponse != nil && r.HTTPResponse.StatusCode != 0 {
+				span.SetAttributes(semconv.HTTPStatusCode(r.HTTPResponse.StatusCode))
+			}
+			if r.RequestID != "" {
+				span.SetAttributes(attribute.String("aws.request_id", r.RequestID))
+			}
+			End(span, r.Error)
+		},
+	})
+}